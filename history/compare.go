@@ -0,0 +1,86 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Change is the difference between two runs on a single server.
+type Change struct {
+	Server string
+
+	// Before and After are the outcomes on the server in each run. Nil if
+	// the server was not part of the run.
+	Before *Host
+	After  *Host
+}
+
+// StatusChanged returns true if the status of the server changed (see
+// Host.Status), including servers only found in one of the runs.
+func (c *Change) StatusChanged() bool {
+	return c.Before.Status() != c.After.Status()
+}
+
+// OutputChanged returns true if the stdout output of the server changed.
+// Servers only found in one of the runs are not considered.
+func (c *Change) OutputChanged() bool {
+	if c.Before == nil || c.After == nil {
+		return false
+	}
+
+	return strings.TrimSuffix(c.Before.Stdout(), "\n") !=
+		strings.TrimSuffix(c.After.Stdout(), "\n")
+}
+
+// Changed returns true if either the status or the output changed.
+func (c *Change) Changed() bool {
+	return c.StatusChanged() || c.OutputChanged()
+}
+
+// Compare returns the changes on each server between the two runs, in the
+// order of the servers in the first run, followed by the servers only found
+// in the second run.
+func Compare(before *Run, after *Run) []Change {
+	changes := make([]Change, 0, len(before.Hosts))
+	seen := make(map[string]bool, len(before.Hosts))
+
+	for ix := range before.Hosts {
+		host := &before.Hosts[ix]
+		other, _ := after.Host(host.Server)
+
+		changes = append(changes, Change{
+			Server: host.Server,
+			Before: host,
+			After:  other,
+		})
+		seen[host.Server] = true
+	}
+
+	for ix := range after.Hosts {
+		host := &after.Hosts[ix]
+		if !seen[host.Server] {
+			changes = append(changes, Change{Server: host.Server, After: host})
+		}
+	}
+
+	return changes
+}
+
+// Status returns a short description of the outcome on the host, like
+// "exit 0", "skipped", or "missing" if there is no host.
+func (h *Host) Status() string {
+	switch {
+	case h == nil:
+		return "missing"
+	case h.Skipped:
+		return "skipped"
+	case h.Error != "":
+		return "error"
+	case !h.Exited:
+		return "unfinished"
+	case h.Failed:
+		return fmt.Sprintf("exit %d (failed)", h.ExitCode)
+	default:
+		return fmt.Sprintf("exit %d", h.ExitCode)
+	}
+}
@@ -0,0 +1,57 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/href/ssh-each/term"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	before := testRun(time.Now())
+	after := NewRun("uptime", time.Now(), time.Now(), []term.Outcome{
+		{
+			Server: "web1",
+			Exited: true,
+			Output: []term.Output{{Text: "foo"}},
+		},
+		{
+			Server: "web2",
+			Exited: true,
+			Output: []term.Output{{Text: "baz\n", Stderr: true}},
+		},
+		{
+			Server: "web4",
+			Err:    assert.AnError,
+		},
+	})
+
+	changes := Compare(before, after)
+	assert.Len(t, changes, 4)
+
+	status := make([]string, 0, len(changes))
+	for _, change := range changes {
+		status = append(status,
+			change.Before.Status()+" → "+change.After.Status())
+	}
+	assert.Equal(t, []string{
+		"exit 0 → exit 0",
+		"exit 2 (failed) → exit 0",
+		"skipped → missing",
+		"missing → error",
+	}, status)
+
+	// The trailing newline is ignored, stderr output as well
+	assert.False(t, changes[0].Changed())
+	assert.True(t, changes[1].StatusChanged())
+	assert.False(t, changes[1].OutputChanged())
+	assert.True(t, changes[2].StatusChanged())
+	assert.False(t, changes[2].OutputChanged())
+	assert.Equal(t, "web4", changes[3].Server)
+	assert.True(t, changes[3].Changed())
+
+	after.Hosts[1].Output = []term.Output{{Text: "bar\n"}}
+	changes = Compare(before, after)
+	assert.True(t, changes[1].OutputChanged())
+}
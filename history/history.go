@@ -0,0 +1,384 @@
+// Package history stores the results of past runs, so that their output can
+// be shown again later.
+//
+// Each run is stored as two JSON files in the state directory: One with the
+// summary of the run (RUN-ID.json), and one with the output of each server
+// (RUN-ID.output.json). Listing runs only reads the summaries.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/href/ssh-each/term"
+)
+
+// MaxRuns is the number of runs kept in the store. Older runs are removed
+// when new runs are saved.
+const MaxRuns = 500
+
+// maxSuffix limits the suffixes tried, if a run ID is already taken.
+const maxSuffix = 100
+
+// idLayout is the time layout of run IDs, which makes sure that IDs sort
+// chronologically. The fractional seconds are separated by a dash instead
+// of a dot in the ID. IDs use UTC, so that they keep their order across
+// daylight saving time changes.
+const idLayout = "20060102-150405.000"
+
+// Run is a single invocation, with the outcome on each server.
+type Run struct {
+	ID      string    `json:"id"`
+	Command string    `json:"command"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Hosts   []Host    `json:"hosts"`
+}
+
+// Host is the outcome of a run on a single server.
+type Host struct {
+	Server    string        `json:"server"`
+	Start     time.Time     `json:"start"`
+	End       time.Time     `json:"end"`
+	Exited    bool          `json:"exited"`
+	ExitCode  int           `json:"exit_code"`
+	Failed    bool          `json:"failed"`
+	Error     string        `json:"error,omitempty"`
+	Skipped   bool          `json:"skipped,omitempty"`
+	Truncated bool          `json:"truncated,omitempty"`
+	Output    []term.Output `json:"-"`
+}
+
+// NewRun creates a run from the outcomes of a report.
+func NewRun(
+	command string,
+	start time.Time,
+	end time.Time,
+	outcomes []term.Outcome,
+) *Run {
+	run := &Run{
+		ID:      newID(start),
+		Command: command,
+		Start:   start,
+		End:     end,
+		Hosts:   make([]Host, 0, len(outcomes)),
+	}
+
+	for _, outcome := range outcomes {
+		host := Host{
+			Server:    outcome.Server,
+			Start:     outcome.Start,
+			End:       outcome.End,
+			Exited:    outcome.Exited,
+			ExitCode:  outcome.ExitCode,
+			Failed:    outcome.Failed,
+			Skipped:   outcome.Skipped,
+			Truncated: outcome.Truncated,
+			Output:    outcome.Output,
+		}
+
+		if outcome.Err != nil {
+			host.Error = outcome.Err.Error()
+		}
+
+		run.Hosts = append(run.Hosts, host)
+	}
+
+	return run
+}
+
+// newID returns a new, chronologically sortable run ID.
+func newID(start time.Time) string {
+	return strings.Replace(start.UTC().Format(idLayout), ".", "-", 1)
+}
+
+// Duration returns the time the run took.
+func (r *Run) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// Failures returns the number of servers that did not succeed.
+func (r *Run) Failures() int {
+	failures := 0
+	for _, host := range r.Hosts {
+		if !host.Skipped && !host.Success() {
+			failures++
+		}
+	}
+	return failures
+}
+
+// Host returns the host with the given server name.
+func (r *Run) Host(server string) (*Host, bool) {
+	for ix := range r.Hosts {
+		if r.Hosts[ix].Server == server {
+			return &r.Hosts[ix], true
+		}
+	}
+	return nil, false
+}
+
+// Duration returns the time the commands took on the host.
+func (h *Host) Duration() time.Duration {
+	if h.Start.IsZero() || h.End.IsZero() {
+		return 0
+	}
+	return h.End.Sub(h.Start)
+}
+
+// Success returns true if the commands on the host succeeded.
+func (h *Host) Success() bool {
+	return h.Exited && !h.Failed && h.Error == ""
+}
+
+// Stdout returns the stdout output of the host.
+func (h *Host) Stdout() string {
+	return term.JoinOutput(h.Output, false)
+}
+
+// Store keeps runs in a directory.
+type Store struct {
+	Dir string
+}
+
+// DefaultStore returns the store in the user's state directory
+// ($XDG_STATE_HOME/ssh-each, or ~/.local/state/ssh-each).
+func DefaultStore() (*Store, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+
+	return &Store{Dir: filepath.Join(dir, "ssh-each")}, nil
+}
+
+// summaryPath returns the path of the summary file of the given run.
+func (s *Store) summaryPath(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
+
+// outputPath returns the path of the output file of the given run.
+func (s *Store) outputPath(id string) string {
+	return filepath.Join(s.Dir, id+".output.json")
+}
+
+// Save stores the given run, and removes the oldest runs, if there are more
+// than MaxRuns.
+func (s *Store) Save(run *Run) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+
+	output := make(map[string][]term.Output, len(run.Hosts))
+	for _, host := range run.Hosts {
+		output[host.Server] = host.Output
+	}
+
+	file, err := s.reserve(run)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(file).Encode(output)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := writeJSON(s.summaryPath(run.ID), run); err != nil {
+		return err
+	}
+
+	return s.prune()
+}
+
+// reserve creates the output file of the run, which reserves its ID. If
+// the ID is taken (e.g. by a run started in the same millisecond), a
+// suffix is added to the ID of the run.
+func (s *Store) reserve(run *Run) (*os.File, error) {
+	id := run.ID
+
+	for n := 2; n <= maxSuffix; n++ {
+		file, err := os.OpenFile(s.outputPath(run.ID),
+			os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if !errors.Is(err, os.ErrExist) {
+			return file, err
+		}
+
+		run.ID = fmt.Sprintf("%s-%d", id, n)
+	}
+
+	return nil, fmt.Errorf("run ID taken: %s", id)
+}
+
+// writeJSON writes the value to the given file, readable only by the user.
+func writeJSON(file string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, data, 0o600)
+}
+
+// ids returns the IDs of all stored runs, oldest first.
+func (s *Store) ids() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".output.json") {
+			continue
+		}
+
+		if id, ok := strings.CutSuffix(name, ".json"); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// prune removes the oldest runs, keeping MaxRuns.
+func (s *Store) prune() error {
+	ids, err := s.ids()
+	if err != nil {
+		return err
+	}
+
+	for len(ids) > MaxRuns {
+		for _, file := range []string{
+			s.summaryPath(ids[0]),
+			s.outputPath(ids[0]),
+		} {
+			if err := os.Remove(file); err != nil &&
+				!errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		ids = ids[1:]
+	}
+
+	return nil
+}
+
+// List returns the summaries of all stored runs, oldest first. The output
+// of the hosts is not loaded.
+func (s *Store) List() ([]*Run, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]*Run, 0, len(ids))
+	for _, id := range ids {
+		run, err := s.summary(id)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
+
+// summary loads the summary of the run with the given ID.
+func (s *Store) summary(id string) (*Run, error) {
+	data, err := os.ReadFile(s.summaryPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	run := &Run{}
+	if err := json.Unmarshal(data, run); err != nil {
+		return nil, fmt.Errorf("%s: %w", id, err)
+	}
+
+	return run, nil
+}
+
+// Resolve returns the ID of the run referenced by the given text, which may
+// be a full ID, a unique prefix of an ID, or "last" for the latest run.
+func (s *Store) Resolve(ref string) (string, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return "", err
+	}
+
+	if ref == "last" {
+		if len(ids) == 0 {
+			return "", fmt.Errorf("no runs recorded")
+		}
+		return ids[len(ids)-1], nil
+	}
+
+	matches := make([]string, 0, 1)
+	for _, id := range ids {
+		if id == ref {
+			return id, nil
+		}
+		if strings.HasPrefix(id, ref) {
+			matches = append(matches, id)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no such run: %s", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("ambiguous run: %s matches %d runs",
+			ref, len(matches))
+	}
+}
+
+// Load returns the run referenced by the given text (see Resolve),
+// including the output of all hosts.
+func (s *Store) Load(ref string) (*Run, error) {
+	id, err := s.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := s.summary(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(s.outputPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(map[string][]term.Output)
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, fmt.Errorf("%s: %w", id, err)
+	}
+
+	for ix := range run.Hosts {
+		run.Hosts[ix].Output = output[run.Hosts[ix].Server]
+	}
+
+	return run, nil
+}
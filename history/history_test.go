@@ -0,0 +1,167 @@
+package history
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/href/ssh-each/term"
+	"github.com/stretchr/testify/assert"
+)
+
+// testRun returns a run with a successful, a failed and a skipped host.
+func testRun(start time.Time) *Run {
+	return NewRun("uptime", start, start.Add(time.Second), []term.Outcome{
+		{
+			Server:   "web1",
+			Start:    start,
+			End:      start.Add(time.Second),
+			Exited:   true,
+			ExitCode: 0,
+			Output:   []term.Output{{Text: "foo\n"}},
+		},
+		{
+			Server:   "web2",
+			Exited:   true,
+			ExitCode: 2,
+			Failed:   true,
+			Output:   []term.Output{{Text: "bar\n", Stderr: true}},
+		},
+		{
+			Server:  "web3",
+			Skipped: true,
+		},
+	})
+}
+
+func TestSaveLoad(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	assert.NoError(t, store.Save(testRun(start)))
+
+	runs, err := store.List()
+	assert.NoError(t, err)
+	assert.Len(t, runs, 1)
+	assert.Equal(t, "uptime", runs[0].Command)
+	assert.Equal(t, 1, runs[0].Failures())
+	assert.Nil(t, runs[0].Hosts[0].Output)
+
+	run, err := store.Load("last")
+	assert.NoError(t, err)
+	assert.Equal(t, runs[0].ID, run.ID)
+	assert.Equal(t, "foo\n", run.Hosts[0].Stdout())
+	assert.True(t, run.Hosts[0].Success())
+	assert.Equal(t, time.Second, run.Hosts[0].Duration())
+	assert.False(t, run.Hosts[1].Success())
+	assert.True(t, run.Hosts[2].Skipped)
+
+	info, err := os.Stat(store.summaryPath(run.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestNewID(t *testing.T) {
+	// Daylight saving time ends at 03:00 CEST, the hour is repeated in CET
+	zone := time.FixedZone("CEST", 2*60*60)
+	before := time.Date(2024, 10, 27, 2, 30, 0, 0, zone)
+	after := before.Add(time.Hour).In(time.FixedZone("CET", 60*60))
+
+	assert.Equal(t, "20241027-003000-000", newID(before))
+	assert.Equal(t, "20241027-013000-000", newID(after))
+	assert.Less(t, newID(before), newID(after))
+}
+
+func TestSaveSameID(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	first, second := testRun(start), testRun(start)
+	second.Command = "df -h"
+	assert.NoError(t, store.Save(first))
+	assert.NoError(t, store.Save(second))
+
+	assert.Equal(t, "20240102-030405-000", first.ID)
+	assert.Equal(t, "20240102-030405-000-2", second.ID)
+
+	runs, err := store.List()
+	assert.NoError(t, err)
+	assert.Len(t, runs, 2)
+	assert.Equal(t, "uptime", runs[0].Command)
+	assert.Equal(t, "df -h", runs[1].Command)
+}
+
+func TestResolve(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+
+	_, err := store.Resolve("last")
+	assert.Error(t, err)
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	first, second := testRun(start), testRun(start.Add(time.Hour))
+	assert.NoError(t, store.Save(first))
+	assert.NoError(t, store.Save(second))
+
+	id, err := store.Resolve("20240102-03")
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, id)
+
+	id, err = store.Resolve(second.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, second.ID, id)
+
+	id, err = store.Resolve("last")
+	assert.NoError(t, err)
+	assert.Equal(t, second.ID, id)
+
+	_, err = store.Resolve("2024")
+	assert.ErrorContains(t, err, "ambiguous")
+
+	_, err = store.Resolve("1999")
+	assert.ErrorContains(t, err, "no such run")
+}
+
+func TestPrune(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for ix := 0; ix < MaxRuns+2; ix++ {
+		run := testRun(start.Add(time.Duration(ix) * time.Second))
+		run.ID = fmt.Sprintf("%s-%04d", run.ID, ix)
+		assert.NoError(t, store.Save(run))
+	}
+
+	runs, err := store.List()
+	assert.NoError(t, err)
+	assert.Len(t, runs, MaxRuns)
+	assert.Equal(t, start.Add(2*time.Second), runs[0].Start.UTC())
+
+	entries, err := os.ReadDir(store.Dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, MaxRuns*2)
+}
+
+func TestReplay(t *testing.T) {
+	run := testRun(time.Now())
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	rep := term.NewReport(term.HostReport)
+	rep.Stdout = stdout
+	rep.Stderr = stderr
+
+	run.Replay(&rep)
+	assert.Equal(t, "web1: foo\n", stdout.String())
+	assert.Equal(t, "web2: bar\n", stderr.String())
+	assert.False(t, rep.Success())
+	assert.Equal(t, []string{"web3"}, rep.Skipped())
+
+	stdout.Reset()
+	rep = term.NewReport(term.CheckReport)
+	rep.Stdout = stdout
+
+	run.Replay(&rep, "web1", "web3")
+	assert.Equal(t, "web1: ✓\nweb3: skipped\n", stdout.String())
+	assert.True(t, rep.Success())
+}
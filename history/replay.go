@@ -0,0 +1,106 @@
+package history
+
+import (
+	"errors"
+	"os/exec"
+	"slices"
+	"time"
+
+	"github.com/href/ssh-each/stream"
+	"github.com/href/ssh-each/term"
+)
+
+// Replay passes the recorded results of the run to the report, as if the
+// commands were run again. If servers are given, only those are replayed.
+//
+// The hosts are queued before the first one is replayed, with the recorded
+// steps, so that aligned prefixes fit all of them from the start.
+func (r *Run) Replay(rep *term.Report, servers ...string) {
+	hosts := make([]*Host, 0, len(r.Hosts))
+	for ix := range r.Hosts {
+		host := &r.Hosts[ix]
+
+		if len(servers) > 0 && !slices.Contains(servers, host.Server) {
+			continue
+		}
+
+		hosts = append(hosts, host)
+		for _, output := range host.Output {
+			if output.Step != "" && !slices.Contains(rep.Steps, output.Step) {
+				rep.Steps = append(rep.Steps, output.Step)
+			}
+		}
+	}
+
+	for _, host := range hosts {
+		rep.Queue(host.Server)
+	}
+
+	for _, host := range hosts {
+		host.replay(rep)
+		rep.Done(host.Server)
+	}
+}
+
+// replay passes the recorded results of the host to the report.
+func (h *Host) replay(rep *term.Report) {
+	send := func(cmd *exec.Cmd, result stream.Result, at time.Time) {
+		rep.On(stream.CommandResult{
+			Command: cmd,
+			Result:  result.WithTime(at),
+		})
+	}
+
+	// Skipped hosts only ran their guard, which failed
+	if h.Skipped {
+		guard := &exec.Cmd{}
+		rep.AssociateOrigin(guard, term.Origin{Server: h.Server, Guard: true})
+		send(guard, stream.NewStartResult(), h.Start)
+		send(guard, stream.NewExitResult(1), h.End)
+		return
+	}
+
+	// Use a command per step, so the output is attributed correctly. The
+	// recorded success is used, as the success codes are not recorded.
+	origin := term.Origin{
+		Server:  h.Server,
+		Success: func(int) bool { return !h.Failed },
+	}
+
+	// Only the first command is started, as only the last one ends
+	cmds := make(map[string]*exec.Cmd)
+	cmd := func(step string) *exec.Cmd {
+		if cmds[step] == nil {
+			cmds[step] = &exec.Cmd{}
+			origin.Step = step
+			rep.AssociateOrigin(cmds[step], origin)
+			if len(cmds) == 1 {
+				send(cmds[step], stream.NewStartResult(), h.Start)
+			}
+		}
+		return cmds[step]
+	}
+
+	var last *exec.Cmd
+	for _, output := range h.Output {
+		last = cmd(output.Step)
+
+		resultType := stream.ResultType(stream.StdoutResult)
+		if output.Stderr {
+			resultType = stream.StderrResult
+		}
+
+		send(last, stream.NewOutputResult(resultType, output.Text), output.Time)
+	}
+
+	if last == nil {
+		last = cmd("")
+	}
+
+	switch {
+	case h.Error != "":
+		send(last, stream.NewErrorResult(errors.New(h.Error)), h.End)
+	case h.Exited:
+		send(last, stream.NewExitResult(h.ExitCode), h.End)
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/href/ssh-each/history"
+	"github.com/href/ssh-each/plan"
 	"github.com/href/ssh-each/ssh"
-	"github.com/href/ssh-each/stream"
 	"github.com/href/ssh-each/term"
 	cli "github.com/jawher/mow.cli"
 	"github.com/lithammer/dedent"
@@ -17,7 +20,140 @@ import (
 func getApp() *cli.Cli {
 	app := cli.App("ssh-each", strings.Trim(dedent.Dedent(`
 		Run SSH commands on multiple servers concurrently.
-		Servers can be passed via -s/--servers, or STDIN.
+		Servers can be passed via -s/--servers, --from-ssh-config, or STDIN.
+
+		Servers from SSH config (--from-ssh-config):
+		  Uses the concrete Host entries found in ~/.ssh/config (and its
+		  includes) that match the given glob pattern (e.g. 'prod-*').
+		  Without a pattern (followed by another option, or '=') all of
+		  them are used.
+
+		Labels (--select):
+		  Servers may be followed by labels (e.g. 'web1#role=web,dc=ams'),
+		  which can be used to select servers with expressions like
+		  'role=web && dc!=fra'. Supported are =, !=, !, &&, ||, parentheses,
+		  and globs in values. A label name on its own checks its presence.
+
+		Excluding Servers (-x/--exclude):
+		  Skips servers matching a host, a glob ('web*'), a regex ('/^db\d+/'),
+		  or any of the entries in a file ('@maintenance.txt'). Braces in
+		  globs are expanded ('web{1,2}' is 'web1' and 'web2'). Can be given
+		  multiple times, or with comma separated entries (commas within
+		  regexes and braces do not separate entries).
+
+		Duplicates:
+		  Servers referring to the same user, host and port are only used
+		  once (e.g. 'web1', 'WEB1:22'), with a warning for each duplicate.
+
+		Shuffle and Sample (--shuffle, --sample):
+		  Runs the command in random order, or only on a random subset of
+		  the servers (e.g. '5' or '10%'). Both wait for all servers to be
+		  read before starting the first command.
+
+		Steps (--steps):
+		  Instead of a single COMMAND, runs the steps defined in a YAML file
+		  on each server, one after the other. A server only continues with
+		  the next step, if the previous one succeeded:
+
+		    steps:
+		      - name: stop
+		        command: systemctl stop app
+		        timeout: 30s            # optional, no timeout by default
+		      - name: migrate
+		        command: app migrate
+		        success_codes: [0, 3]   # optional, [0] by default
+		        on_failure: continue    # optional, stop by default
+		      - name: start
+		        command: systemctl start app
+
+		Guards (--only-if):
+		  Runs the given command on each server first, and only continues
+		  with COMMAND (or the steps) if it succeeds. Other servers are
+		  reported as skipped, which does not count as a failure. The output
+		  of the guard is not shown. If ssh fails itself (exit code 255,
+		  e.g. if the server is unreachable), the server is not skipped,
+		  but counts as a failure.
+
+		Master Connections (--control-master):
+		  Opens one master connection per server (see ControlMaster in
+		  ssh_config), which is reused by all commands run on it, and closed
+		  before exiting.
+
+		History (--no-history):
+		  Each run is recorded, including the output of all servers. Use
+		  'ssh-each --history' to list past runs, and 'ssh-each --show
+		  RUN' to show their output again, in any output mode. Use
+		  'ssh-each --compare RUN1 RUN2' to see what changed between two
+		  runs.
+
+		Line Buffering (--raw):
+		  Output is shown line by line, so that lines of different servers
+		  are not mixed. Lines longer than 64KiB are split. Use --raw to show
+		  output as it is written instead, e.g. for progress bars or binary
+		  output (together with --mode plain).
+
+		Timestamps (--timestamps):
+		  Shows when each line of output was received, either as date and
+		  time ('absolute'), or as seconds since the start of the run
+		  ('relative'). Only used by the host and plain modes.
+
+		Progress (--no-progress):
+		  In the modes that do not show output (e.g. check, exit, silent), a
+		  line with the number of queued, running, successful and failed
+		  servers, the elapsed time and the estimated time left is shown on
+		  stderr, if it is a terminal.
+
+		Dashboard (--tui):
+		  Shows a full-screen dashboard with the state, duration and last
+		  line of output of each server, instead of the output. Select a
+		  server to see all its output, filter by state, or cancel the
+		  commands of a server. Once it is closed, a summary is shown,
+		  followed by the output of the modes that show their output once
+		  all servers are done (diff, table, aggregate, tap and markdown).
+		  Other modes are not supported with --tui.
+
+		Prefix (--prefix, --align):
+		  The prefix shown before each line in the host mode can be changed
+		  with a template (see text/template), using {{.Server}},
+		  {{.Stream}} (stdout or stderr), {{.Time}} (e.g. {{.Time.Format
+		  "15:04:05"}}), and {{.Index}} (1 for the first server read).
+		  The default is '{{.Server}}: '. With --align, all servers are read
+		  before the first command is run, and prefixes are padded to the
+		  longest prefix, so that the output lines up.
+
+		Filtering (--grep, --grep-v):
+		  Only shows the lines of output matching the regular expression
+		  (see regexp/syntax), or with --grep-v, the lines not matching it.
+		  Used by the host, plain and buffered modes, and not supported
+		  with --raw, which does not split output into lines. With --mode
+		  match, each server is shown with ✓ if any line matched, x
+		  otherwise, followed by the matching lines, once the server is
+		  done.
+
+		Tables (--fields, --table-format):
+		  With --mode table, the stdout output of each server is parsed as
+		  JSON, or as lines of key=value pairs (e.g. /etc/os-release), and
+		  shown as a table with a row per server. Nested JSON fields are
+		  flattened to their path (e.g. 'version.major', '0.ifname'). By
+		  default, all fields are shown, --fields selects the columns. Use
+		  --table-format to render the table as 'csv' or 'markdown'.
+		  Servers that failed, or whose output could not be parsed, are
+		  left out, and listed on stderr.
+
+		JUnit (--junit):
+		  Writes a JUnit XML report to the given file, once all commands
+		  are done, for CI systems. Each server is a test case, which fails
+		  if its exit code is not successful, with the duration and the
+		  output of the server. Servers whose commands could not be run are
+		  errors, skipped servers are skipped. In the shell, the file is
+		  written after each command.
+
+		Colors (--color):
+		  Shows each server in its own color in the host mode, stderr output
+		  in red, and the status of each server in the check and exit modes
+		  in green or red. Enabled for stdout and stderr separately, if it
+		  is a terminal and NO_COLOR is not set ('auto'), or with 'always'.
+		  Disabled with 'never'.
 
 		Output Modes (-m/--mode):
 		  host      shows server before each outputted line, default
@@ -27,98 +163,444 @@ func getApp() *cli.Cli {
 		  check-no  show server and x on success, nothing otherwise
 		  exit      show server and exit code, no output
 		  slient    show nothing
+		  diff      show the difference of each server's output to the
+		            output of the first server (or --reference)
+		  buffered  show the output of each server as a single block,
+		            once the server is done (or with --ordered, once it
+		            and all servers before it are done)
+		  match     show server and ✓ if any line matched --grep (or
+		            --grep-v), x otherwise, and the matching lines
+		  table     show the fields of each server's output in a table
+		  aggregate show count, min, max, mean and percentiles of the
+		            number on the last line each server wrote to stdout
+		            (optionally followed by %), and the outlier servers;
+		            servers that failed or wrote no number are left out
+		  tap       show the result of each server as a TAP test
+		  markdown  show a Markdown table with the status, exit code,
+		            duration and first line of output of each server
 
 		Exit Code:
 		  ssh-each will return an exit code of 0, if at least one command
 		  completed and all completed commands were successful.
 
 		  This can be overwritten by using --exit-ok.
+
+		Local Commands:
+		  Instead of running a single command, the following flags start
+		  a local command, if given as first argument (see 'ssh-each
+		  --history --help'):
+
+		  --shell   run commands interactively on the selected servers
+		  --history list past runs
+		  --show    show the output of a past run
+		  --compare compare two past runs
 	`), "\r\n"))
 
-	app.Spec = strings.Join([]string{
-		"[-t]",
-		"[-s=<comma-separated-servers>]",
-		"[-w=<workers>]",
-		"[-u=<user>]",
-		"[-p=<port>]",
-		"[-m=<mode>]",
-		"[--exit-ok]",
-		"COMMAND",
-	}, " ")
+	app.Spec = strings.Join(optionsSpec, " ") +
+		" [--exit-ok] [--only-if=<command>] [--steps=<file>] [COMMAND]"
 
 	exitOK := false
-	builder := ssh.CommandBuilder{}
-	servers := app.StringOpt("s servers", "", "Comma separated servers")
-	workers := app.IntOpt("w workers", 16, "Concurrent SSH processes")
-	port := app.IntOpt("p port", 0, "Default port")
-	mode := app.StringOpt("m mode", "host", "Output mode")
-	app.BoolOptPtr(&builder.TTY, "t tty", false, "Use pseudo-terminal")
+	opts := options{}
+	opts.register(app.Cmd)
 	app.BoolOptPtr(&exitOK, "exit-ok", false, "Ignore server command errors")
-	app.StringOptPtr(&builder.ExplicitUser, "u user", "", "Default user")
-	app.StringArgPtr(&builder.Command, "COMMAND", "", "Command to execute")
+	steps := app.StringOpt("steps", "", "Run the steps in the given file")
+	guard := app.StringOpt("only-if", "", "Skip servers where this fails")
+	command := app.StringArg("COMMAND", "", "Command to execute")
 
 	app.Action = func() {
+		var p *plan.Plan
 
-		// Validate inputs
-		if *workers <= 0 {
-			fmt.Println("Must use at least one worker")
+		switch {
+		case *steps != "" && *command != "":
+			fmt.Println("Either use COMMAND or --steps, not both")
+			os.Exit(1)
+		case *steps != "":
+			var err error
+			p, err = plan.Load(*steps)
+			if err != nil {
+				fmt.Println("Invalid steps:", err)
+				os.Exit(1)
+			}
+		case *command != "":
+			p = plan.Single(*command)
+		default:
+			app.PrintHelp()
 			os.Exit(1)
 		}
 
-		if *port < 0 || 65535 < *port {
-			fmt.Println("Invalid default port")
+		if *guard != "" {
+			p = p.WithGuard(*guard)
+		}
+
+		reader, mode := opts.setup()
+
+		// Abort on interrupt
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		rep := opts.report(mode)
+		start := time.Now()
+		rep.Epoch = start
+		rep.Steps = p.Names()
+
+		if opts.progress(mode) {
+			rep.ShowProgress(os.Stderr)
+		}
+
+		// Generate commands from --servers and from STDIN
+		targets := opts.targets(ctx, &rep, reader)
+		opts.run(ctx, &rep, p, targets)
+		rep.Finish()
+		opts.close()
+
+		description := describe(*command, *steps, *guard)
+		opts.record(description, start, &rep)
+		opts.writeJUnit(description, start, time.Now(), &rep)
+
+		if exitOK || rep.Success() {
+			os.Exit(0)
+		} else {
 			os.Exit(1)
 		}
-		builder.ExplicitPort = uint16(*port)
+	}
+
+	return app
+}
+
+// localCommand is a command that does not run a remote command, selected
+// with a flag given as first argument. Flags are used instead of
+// subcommands, as they cannot be mistaken for a remote command.
+type localCommand struct {
+	desc string
+	init func(cmd *cli.Cmd)
+}
+
+// localCommands are the local commands by their flag.
+var localCommands = map[string]localCommand{
+	"--shell":   {"Run commands interactively", shellCommand},
+	"--history": {"List past runs", historyCommand},
+	"--show":    {"Show the output of a past run", showCommand},
+	"--compare": {"Compare two past runs", compareCommand},
+}
+
+// appFor returns the app for the given arguments, and the arguments to run
+// it with: The app of the local command, if its flag is the first
+// argument, or the app running remote commands.
+func appFor(args []string) (*cli.Cli, []string) {
+	if len(args) > 1 {
+		if command, ok := localCommands[args[1]]; ok {
+			name := "ssh-each " + args[1]
+			app := cli.App(name, command.desc)
+			command.init(app.Cmd)
+			return app, append([]string{name}, args[2:]...)
+		}
+	}
 
-		reportMode, ok := term.ReportModeFromString(*mode)
-		if !ok {
-			fmt.Println("Unknown report mode: ", *mode)
+	return getApp(), args
+}
+
+// describe returns the description of a run stored in the history.
+func describe(command string, steps string, guard string) string {
+	description := command
+	if steps != "" {
+		description = "--steps " + steps
+	}
+
+	if guard != "" {
+		description += " (only if: " + guard + ")"
+	}
+
+	return description
+}
+
+// shellCommand configures the interactive shell command.
+func shellCommand(cmd *cli.Cmd) {
+	cmd.LongDesc = strings.Trim(dedent.Dedent(`
+		Run commands interactively on a set of servers.
+
+		The servers are selected once, using the same options as when
+		running a single command. Each command entered is then run on all
+		of them. Enter :help in the shell to see the built-in commands.
+
+		Interrupting a running command (Ctrl-C) only cancels that command.
+
+		Use --control-master to keep one connection open per server, so
+		that each command does not have to connect again.
+	`), "\r\n")
+
+	cmd.Spec = strings.Join(optionsSpec, " ")
+
+	opts := options{}
+	opts.register(cmd)
+
+	cmd.Action = func() {
+		reader, mode := opts.setup()
+
+		targets := make([]ssh.Target, 0)
+		for t := range opts.builder.Targets(context.Background(), reader) {
+			targets = append(targets, t)
+		}
+
+		if len(targets) == 0 {
+			fmt.Println("No servers given")
 			os.Exit(1)
 		}
 
-		reader := term.CombinedReader(*servers)
-		if reader == nil {
-			fmt.Println("Neither stdin, nor -s/--servers given")
+		input, err := term.TerminalInput()
+		if err != nil {
+			fmt.Println("Could not open terminal:", err)
+			opts.close()
 			os.Exit(1)
 		}
 
-		// Abort on interrupt
-		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
-		mux := stream.NewMux(ctx, uint(*workers))
-		rep := term.NewReport(reportMode)
+		shell := term.Shell{
+			Targets:   targets,
+			Mode:      mode,
+			CheckMode: opts.checkShellMode,
+			In:        input,
+			Out:       os.Stderr,
+			Run: func(
+				ctx context.Context,
+				command string,
+				targets []ssh.Target,
+				mode term.ReportMode,
+			) {
+				rep := opts.report(mode)
+				start := time.Now()
+				rep.Epoch = start
 
-		// Generate commands from --servers and from STDIN
-		go func() {
-			linked := builder.FromReader(ctx, reader)
-			for link := range linked {
-				rep.Associate(link.Server, link.Command)
-				mux.Submit(link.Command)
-			}
+				if opts.progress(mode) {
+					rep.ShowProgress(os.Stderr)
+				}
+				ch := queueTargets(ctx, &rep, targets)
+				opts.run(ctx, &rep, plan.Single(command), ch)
+				rep.Finish()
+				opts.record(command, start, &rep)
+				opts.writeJUnit(command, start, time.Now(), &rep)
+			},
+		}
 
-			// Once all commands have seized, stop Mux from accepting more
-			// commands (this causes the workers to wind down).
-			mux.Shut()
-		}()
+		err = shell.Loop()
+		opts.close()
 
-		// Report results
-		for result := range mux.Results() {
-			rep.On(result)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+	}
+}
 
-		if exitOK || rep.Success() {
+// historyCommand configures the command listing past runs.
+func historyCommand(cmd *cli.Cmd) {
+	cmd.LongDesc = strings.Trim(dedent.Dedent(`
+		List past runs, oldest first.
+
+		Each run is recorded with its command, and the exit code, duration
+		and output of each server (unless --no-history is used). The last
+		500 runs are kept in $XDG_STATE_HOME/ssh-each (by default
+		~/.local/state/ssh-each).
+
+		Use the run ID with 'ssh-each --show' to show the output of a run.
+	`), "\r\n")
+
+	cmd.Spec = "[-n=<runs>]"
+	limit := cmd.IntOpt("n last", 0, "Only list the last n runs")
+
+	cmd.Action = func() {
+		store, err := history.DefaultStore()
+		if err != nil {
+			fmt.Println("Could not open history:", err)
+			os.Exit(1)
+		}
+
+		runs, err := store.List()
+		if err != nil {
+			fmt.Println("Could not read history:", err)
+			os.Exit(1)
+		}
+
+		if *limit > 0 && len(runs) > *limit {
+			runs = runs[len(runs)-*limit:]
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RUN-ID\tSTART\tDURATION\tHOSTS\tFAILED\tCOMMAND")
+		for _, run := range runs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n",
+				run.ID,
+				run.Start.Local().Format(time.DateTime),
+				run.Duration().Round(time.Millisecond),
+				len(run.Hosts),
+				run.Failures(),
+				run.Command,
+			)
+		}
+		w.Flush()
+	}
+}
+
+// showCommand configures the command showing the output of a past run.
+func showCommand(cmd *cli.Cmd) {
+	cmd.LongDesc = strings.Trim(dedent.Dedent(`
+		Show the output of a past run, in any output mode.
+
+		RUN is a run ID listed by 'ssh-each --history', a unique prefix of
+		one, or 'last' for the latest run. If servers are given, only their
+		output is shown.
+
+		The exit code is the one of the original run (limited to the given
+		servers).
+	`), "\r\n")
+
+	cmd.Spec = strings.Join(reportOptionsSpec, " ") + " RUN [SERVER...]"
+
+	opts := reportOptions{}
+	opts.register(cmd)
+	ref := cmd.StringArg("RUN", "", "Run to show")
+	servers := cmd.StringsArg("SERVER", nil, "Servers to show")
+
+	cmd.Action = func() {
+		mode := opts.setup()
+
+		store, err := history.DefaultStore()
+		if err != nil {
+			fmt.Println("Could not open history:", err)
+			os.Exit(1)
+		}
+
+		run, err := store.Load(*ref)
+		if err != nil {
+			fmt.Println("Could not load run:", err)
+			os.Exit(1)
+		}
+
+		for _, server := range *servers {
+			if _, ok := run.Host(server); !ok {
+				fmt.Printf("Server not in run %s: %s\n", run.ID, server)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "# %s at %s: %s\n",
+			run.ID, run.Start.Local().Format(time.DateTime), run.Command)
+
+		rep := opts.report(mode)
+		rep.Epoch = run.Start
+		run.Replay(&rep, *servers...)
+		rep.Finish()
+		opts.writeJUnit(run.Command, run.Start, run.End, &rep)
+
+		if rep.Success() {
 			os.Exit(0)
 		} else {
 			os.Exit(1)
 		}
 	}
+}
 
-	return app
+// compareCommand configures the command comparing two past runs.
+func compareCommand(cmd *cli.Cmd) {
+	cmd.LongDesc = strings.Trim(dedent.Dedent(`
+		Compare two past runs, showing the servers that changed their exit
+		status, or their output (stdout only).
+
+		RUN1 and RUN2 are run IDs listed by 'ssh-each --history', or unique
+		prefixes of them. 'last' refers to the latest run. Typically both
+		runs are of the same command, before and after a change.
+
+		The exit code is 0 if nothing changed, and 1 otherwise.
+	`), "\r\n")
+
+	cmd.Spec = "[-d] [-a] RUN1 RUN2"
+
+	diff := cmd.BoolOpt("d diff", false, "Show how the output changed")
+	all := cmd.BoolOpt("a all", false, "Show unchanged servers as well")
+	refs := []*string{
+		cmd.StringArg("RUN1", "", "Run before"),
+		cmd.StringArg("RUN2", "", "Run after"),
+	}
+
+	cmd.Action = func() {
+		store, err := history.DefaultStore()
+		if err != nil {
+			fmt.Println("Could not open history:", err)
+			os.Exit(1)
+		}
+
+		runs := make([]*history.Run, len(refs))
+		for ix, ref := range refs {
+			runs[ix], err = store.Load(*ref)
+			if err != nil {
+				fmt.Println("Could not load run:", err)
+				os.Exit(1)
+			}
+
+			fmt.Fprintf(os.Stderr, "# %s at %s: %s\n", runs[ix].ID,
+				runs[ix].Start.Local().Format(time.DateTime), runs[ix].Command)
+		}
+
+		if runs[0].Command != runs[1].Command {
+			fmt.Fprintln(os.Stderr, "# warning: the commands differ")
+		}
+
+		changed := 0
+		for _, change := range history.Compare(runs[0], runs[1]) {
+			switch {
+			case change.StatusChanged():
+				fmt.Printf("%s: %s → %s\n", change.Server,
+					change.Before.Status(), change.After.Status())
+			case change.OutputChanged():
+				fmt.Printf("%s: output changed\n", change.Server)
+			case *all:
+				fmt.Printf("%s: unchanged\n", change.Server)
+			}
+
+			if change.Changed() {
+				changed++
+			}
+
+			if *diff && change.OutputChanged() {
+				text, err := term.UnifiedDiff(
+					runs[0].ID, runs[1].ID,
+					change.Before.Stdout(), change.After.Stdout())
+				if err != nil {
+					fmt.Println(change.Server, "error:", err)
+					continue
+				}
+				fmt.Print(text)
+			}
+		}
+
+		if changed > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// optionalValueArgs returns the arguments with an empty value added to
+// the options with an optional value (--from-ssh-config), if they are given
+// without one: As the last argument, before another option, or with '='.
+func optionalValueArgs(args []string) []string {
+	expanded := make([]string, 0, len(args)+1)
+
+	for ix, arg := range args {
+		switch {
+		case arg == "--":
+			return append(expanded, args[ix:]...)
+		case arg == "--from-ssh-config=":
+			expanded = append(expanded, "--from-ssh-config", "")
+		case arg == "--from-ssh-config" &&
+			(ix+1 == len(args) || strings.HasPrefix(args[ix+1], "-")):
+			expanded = append(expanded, arg, "")
+		default:
+			expanded = append(expanded, arg)
+		}
+	}
+
+	return expanded
 }
 
 func main() {
-	app := getApp()
-	err := app.Run(os.Args)
+	app, args := appFor(optionalValueArgs(os.Args))
+	err := app.Run(args)
 
 	if err != nil {
 		fmt.Println(err)
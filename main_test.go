@@ -14,3 +14,40 @@ func TestAppHelp(t *testing.T) {
 	app.ErrorHandling = flag.ContinueOnError
 	assert.NoError(t, app.Run([]string{"ssh-each", "-h"}))
 }
+
+func TestOptionalValueArgs(t *testing.T) {
+	assert.Equal(t,
+		[]string{"ssh-each", "--from-ssh-config", "", "-m", "check", "uptime"},
+		optionalValueArgs(
+			[]string{"ssh-each", "--from-ssh-config", "-m", "check", "uptime"}))
+
+	assert.Equal(t,
+		[]string{"ssh-each", "--from-ssh-config", "", "uptime"},
+		optionalValueArgs([]string{"ssh-each", "--from-ssh-config=", "uptime"}))
+
+	assert.Equal(t,
+		[]string{"ssh-each", "--from-ssh-config", "prod-*", "uptime"},
+		optionalValueArgs(
+			[]string{"ssh-each", "--from-ssh-config", "prod-*", "uptime"}))
+
+	assert.Equal(t,
+		[]string{"ssh-each", "-s", "web1", "--", "echo", "--from-ssh-config"},
+		optionalValueArgs(
+			[]string{"ssh-each", "-s", "web1", "--", "echo", "--from-ssh-config"}))
+}
+
+func TestAppFor(t *testing.T) {
+	// Local commands are selected by a flag given as first argument
+	_, args := appFor([]string{"ssh-each", "--history", "-n", "2"})
+	assert.Equal(t, []string{"ssh-each --history", "-n", "2"}, args)
+
+	// Anywhere else, they are part of the remote command
+	_, args = appFor([]string{"ssh-each", "-s", "web1", "history"})
+	assert.Equal(t, []string{"ssh-each", "-s", "web1", "history"}, args)
+
+	for name := range localCommands {
+		app, args := appFor([]string{"ssh-each", name, "-h"})
+		app.ErrorHandling = flag.ContinueOnError
+		assert.NoError(t, app.Run(args), name)
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/href/ssh-each/history"
+	"github.com/href/ssh-each/plan"
+	"github.com/href/ssh-each/ssh"
+	"github.com/href/ssh-each/stream"
+	"github.com/href/ssh-each/term"
+	cli "github.com/jawher/mow.cli"
+)
+
+// reportOptionsSpec is the spec of the options shared by all commands that
+// report results.
+var reportOptionsSpec = []string{
+	"[-m=<mode>]",
+	"[--reference=<server>]",
+	"[--timestamps=<format>]",
+	"[--color=<when>]",
+	"[--prefix=<template>]",
+	"[--align]",
+	"[--ordered]",
+	"[--grep=<regex>]",
+	"[--grep-v=<regex>]",
+	"[--fields=<fields>]",
+	"[--table-format=<format>]",
+	"[--junit=<file>]",
+}
+
+// reportOptions configure how results are reported.
+type reportOptions struct {
+	mode       *string
+	modeSet    bool
+	reference  *string
+	timestamps *string
+	color      *string
+	prefix     *string
+	align      *bool
+	ordered    *bool
+	grep       *string
+	grepV      *string
+	fields     *string
+	table      *string
+	junit      *string
+
+	// parsed by setup
+	timestampFormat term.Timestamps
+	colorMode       term.ColorMode
+	prefixTemplate  *template.Template
+	grepExpr        *regexp.Regexp
+	grepVExpr       *regexp.Regexp
+	tableFormat     term.TableFormat
+}
+
+// register adds the report options to the given command.
+func (o *reportOptions) register(cmd *cli.Cmd) {
+	o.mode = cmd.String(cli.StringOpt{
+		Name:      "m mode",
+		Value:     "host",
+		Desc:      "Output mode",
+		SetByUser: &o.modeSet,
+	})
+	o.reference = cmd.StringOpt(
+		"reference", "", "Server to compare to in diff mode")
+	o.timestamps = cmd.StringOpt(
+		"timestamps", "", "Timestamp output (absolute or relative)")
+	o.color = cmd.StringOpt(
+		"color", "auto", "Colorize output (auto, always or never)")
+	o.prefix = cmd.StringOpt(
+		"prefix", "", "Template of the prefix in host mode")
+	o.align = cmd.BoolOpt("align", false, "Align the output of all servers")
+	o.ordered = cmd.BoolOpt(
+		"ordered", false, "Show buffered output in the order of the servers")
+	o.grep = cmd.StringOpt("grep", "", "Only show lines matching regex")
+	o.grepV = cmd.StringOpt("grep-v", "", "Hide lines matching regex")
+	o.fields = cmd.StringOpt(
+		"fields", "", "Comma separated fields shown in table mode")
+	o.table = cmd.StringOpt(
+		"table-format", "text", "Table format (text, csv or markdown)")
+	o.junit = cmd.StringOpt("junit", "", "Write a JUnit XML report to file")
+}
+
+// setup validates the report options and returns the report mode selected.
+// Exits if an option is invalid.
+func (o *reportOptions) setup() term.ReportMode {
+	mode, ok := term.ReportModeFromString(*o.mode)
+	if !ok {
+		fmt.Println("Unknown report mode: ", *o.mode)
+		os.Exit(1)
+	}
+
+	o.timestampFormat, ok = term.TimestampsFromString(*o.timestamps)
+	if !ok {
+		fmt.Println("Unknown timestamp format: ", *o.timestamps)
+		os.Exit(1)
+	}
+
+	o.colorMode, ok = term.ColorModeFromString(*o.color)
+	if !ok {
+		fmt.Println("Unknown color mode: ", *o.color)
+		os.Exit(1)
+	}
+
+	o.tableFormat, ok = term.TableFormatFromString(*o.table)
+	if !ok {
+		fmt.Println("Unknown table format: ", *o.table)
+		os.Exit(1)
+	}
+
+	if err := o.checkMode(mode); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	o.grepExpr = compileGrep("--grep", *o.grep)
+	o.grepVExpr = compileGrep("--grep-v", *o.grepV)
+
+	if *o.prefix != "" {
+		var err error
+		o.prefixTemplate, err = term.ParsePrefix(*o.prefix)
+		if err != nil {
+			fmt.Println("Invalid prefix:", err)
+			os.Exit(1)
+		}
+	}
+
+	return mode
+}
+
+// checkMode returns an error if the given mode cannot be used with the
+// report options. Must be called after setup.
+func (o *reportOptions) checkMode(mode term.ReportMode) error {
+	if *o.ordered && mode != term.BufferedReport {
+		return errors.New("--ordered is only supported by --mode buffered")
+	}
+
+	if mode == term.MatchReport && *o.grep == "" && *o.grepV == "" {
+		return errors.New("--mode match requires --grep or --grep-v")
+	}
+
+	if mode != term.TableReport &&
+		(*o.fields != "" || o.tableFormat != term.TextTable) {
+		return errors.New("--fields and --table-format are only supported " +
+			"by --mode table")
+	}
+
+	return nil
+}
+
+// compileGrep compiles the expression of the given grep option, or returns
+// nil if it is not set. Exits if the expression is invalid.
+func compileGrep(option string, expr string) *regexp.Regexp {
+	if expr == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		fmt.Printf("Invalid %s: %s\n", option, err)
+		os.Exit(1)
+	}
+
+	return re
+}
+
+// report returns a new report for the given mode, configured according to
+// the options.
+func (o *reportOptions) report(mode term.ReportMode) term.Report {
+	rep := term.NewReport(mode)
+	rep.Reference = *o.reference
+	rep.Timestamps = o.timestampFormat
+	rep.Color = o.colorMode.Enabled(rep.Stdout)
+	rep.ColorStderr = o.colorMode.Enabled(rep.Stderr)
+	rep.Prefix = o.prefixTemplate
+	rep.Align = *o.align
+	rep.Ordered = *o.ordered
+	rep.Grep = o.grepExpr
+	rep.GrepV = o.grepVExpr
+	rep.TableFormat = o.tableFormat
+	rep.Capture = *o.junit != ""
+	if *o.fields != "" {
+		for _, field := range strings.Split(*o.fields, ",") {
+			rep.Fields = append(rep.Fields, strings.TrimSpace(field))
+		}
+	}
+	return rep
+}
+
+// writeJUnit writes the outcomes of the report to the JUnit XML report, if
+// enabled. Exits if the report cannot be written.
+func (o *reportOptions) writeJUnit(
+	name string,
+	start time.Time,
+	end time.Time,
+	rep *term.Report,
+) {
+	if *o.junit == "" {
+		return
+	}
+
+	file, err := os.Create(*o.junit)
+	if err == nil {
+		err = term.WriteJUnit(file, name, start, end, rep.Outcomes())
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not write JUnit report:", err)
+		os.Exit(1)
+	}
+}
+
+// optionsSpec is the spec of the options shared by all commands that run
+// commands on servers.
+var optionsSpec = append([]string{
+	"[-t]",
+	"[-s=<comma-separated-servers>]",
+	"[-w=<workers>]",
+	"[-u=<user>]",
+	"[-p=<port>]",
+	"[--from-ssh-config=<pattern>]",
+	"[--select=<expression>]",
+	"[-x=<exclude>...]",
+	"[--shuffle]",
+	"[--sample=<count-or-percent>]",
+	"[--control-master]",
+	"[--no-history]",
+	"[--raw]",
+	"[--no-progress]",
+	"[--tui]",
+}, reportOptionsSpec...)
+
+// options are the options shared by all commands that run commands on
+// servers. They select the servers and configure how commands are run on
+// them, and how the results are reported.
+type options struct {
+	reportOptions
+
+	builder    ssh.CommandBuilder
+	servers    *string
+	workers    *int
+	port       *int
+	sshConfig  *string
+	useConfig  bool
+	selector   *string
+	exclude    *[]string
+	sample     *string
+	control    *bool
+	noHistory  *bool
+	raw        *bool
+	noProgress *bool
+	tui        *bool
+}
+
+// register adds the options to the given command.
+func (o *options) register(cmd *cli.Cmd) {
+	o.reportOptions.register(cmd)
+	o.servers = cmd.StringOpt("s servers", "", "Comma separated servers")
+	o.workers = cmd.IntOpt("w workers", 16, "Concurrent SSH processes")
+	o.port = cmd.IntOpt("p port", 0, "Default port")
+	o.sshConfig = cmd.String(cli.StringOpt{
+		Name:      "from-ssh-config",
+		Desc:      "Servers from ~/.ssh/config matching pattern",
+		SetByUser: &o.useConfig,
+	})
+	o.selector = cmd.StringOpt(
+		"select", "", "Only use servers matching labels")
+	o.exclude = cmd.StringsOpt("x exclude", nil, "Servers to skip")
+	o.sample = cmd.StringOpt(
+		"sample", "", "Random subset of servers (N or N%)")
+	cmd.BoolOptPtr(
+		&o.builder.Shuffle, "shuffle", false, "Randomize server order")
+	o.control = cmd.BoolOpt(
+		"control-master", false, "Reuse one connection per server")
+	o.noHistory = cmd.BoolOpt("no-history", false, "Do not record the run")
+	o.raw = cmd.BoolOpt("raw", false, "Pass output on as written, not by line")
+	o.noProgress = cmd.BoolOpt("no-progress", false, "Hide the progress line")
+	o.tui = cmd.BoolOpt("tui", false, "Show a dashboard of all servers")
+	cmd.BoolOptPtr(&o.builder.TTY, "t tty", false, "Use pseudo-terminal")
+	cmd.StringOptPtr(&o.builder.ExplicitUser, "u user", "", "Default user")
+}
+
+// setup validates the options and configures the builder accordingly.
+// Returns the reader to read the servers from, and the report mode. Exits
+// if the options are invalid.
+func (o *options) setup() (io.Reader, term.ReportMode) {
+	if *o.workers <= 0 {
+		fmt.Println("Must use at least one worker")
+		os.Exit(1)
+	}
+
+	if *o.port < 0 || 65535 < *o.port {
+		fmt.Println("Invalid default port")
+		os.Exit(1)
+	}
+	o.builder.ExplicitPort = uint16(*o.port)
+
+	if *o.selector != "" {
+		var err error
+		o.builder.Selector, err = ssh.ParseSelector(*o.selector)
+		if err != nil {
+			fmt.Println("Invalid selector:", err)
+			os.Exit(1)
+		}
+	}
+
+	if len(*o.exclude) > 0 {
+		var err error
+		o.builder.Exclude, err = ssh.ParseExclusion(*o.exclude)
+		if err != nil {
+			fmt.Println("Invalid exclude:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *o.sample != "" {
+		var err error
+		o.builder.Sample, err = ssh.ParseSample(*o.sample)
+		if err != nil {
+			fmt.Println("Invalid sample:", err)
+			os.Exit(1)
+		}
+	}
+
+	o.builder.Duplicate = func(server string, original string) {
+		fmt.Fprintf(os.Stderr,
+			"Warning: skipping %s, same as %s\n", server, original)
+	}
+
+	reportMode := o.reportOptions.setup()
+
+	if err := o.checkMode(reportMode); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// The dashboard replaces the output, only the final output is shown
+	if *o.tui && o.modeSet && !reportMode.Final() {
+		fmt.Printf("--mode %s is not supported with --tui\n", *o.mode)
+		os.Exit(1)
+	}
+
+	// An empty pattern selects all hosts
+	var configHosts []string
+	if o.useConfig {
+		path, err := ssh.UserConfigPath()
+		if err == nil {
+			configHosts, err = ssh.ConfigHosts(path, *o.sshConfig)
+		}
+		if err != nil {
+			fmt.Println("Could not read SSH config:", err)
+			os.Exit(1)
+		}
+		if len(configHosts) == 0 {
+			fmt.Printf("No hosts in %s match %q\n", path, *o.sshConfig)
+			os.Exit(1)
+		}
+	}
+
+	reader := term.CombinedReader(*o.servers, term.LinesReader(configHosts))
+	if reader == nil {
+		fmt.Println("Neither stdin, -s/--servers, nor --from-ssh-config given")
+		os.Exit(1)
+	}
+
+	if *o.control {
+		var err error
+		o.builder.Control, err = ssh.NewControlMaster()
+		if err != nil {
+			fmt.Println("Could not create control socket directory:", err)
+			os.Exit(1)
+		}
+	}
+
+	return reader, reportMode
+}
+
+// checkMode returns an error if the given mode cannot be used with the
+// options (see reportOptions.checkMode). Must be called after setup.
+func (o *options) checkMode(mode term.ReportMode) error {
+	if err := o.reportOptions.checkMode(mode); err != nil {
+		return err
+	}
+
+	// Without line buffering, output is not split into lines
+	if *o.raw && (*o.grep != "" || *o.grepV != "" ||
+		mode == term.MatchReport) {
+		return errors.New("--grep, --grep-v and --mode match are not " +
+			"supported with --raw")
+	}
+
+	return nil
+}
+
+// checkShellMode returns an error if the given mode, selected in the
+// shell, cannot be used with the options.
+func (o *options) checkShellMode(mode term.ReportMode) error {
+	if *o.tui && !mode.Final() {
+		return errors.New("only the diff, table, aggregate, tap and " +
+			"markdown modes are supported with --tui")
+	}
+
+	return o.checkMode(mode)
+}
+
+// report returns a new report for the given mode, configured according to
+// the options.
+func (o *options) report(mode term.ReportMode) term.Report {
+	rep := o.reportOptions.report(mode)
+	rep.Capture = rep.Capture || !*o.noHistory || *o.tui
+	return rep
+}
+
+// progress returns true if a progress line should be shown in the given
+// mode: If the output is a terminal, and the mode does not show output.
+func (o *options) progress(mode term.ReportMode) bool {
+	return !*o.noProgress && !*o.tui && !mode.Streams() &&
+		term.IsTerminal(os.Stdout) && term.IsTerminal(os.Stderr)
+}
+
+// record stores the run in the history, unless disabled. Errors are shown,
+// but do not stop ssh-each.
+func (o *options) record(command string, start time.Time, rep *term.Report) {
+	if *o.noHistory {
+		return
+	}
+
+	store, err := history.DefaultStore()
+	if err == nil {
+		run := history.NewRun(command, start, time.Now(), rep.Outcomes())
+		err = store.Save(run)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not record run:", err)
+	}
+}
+
+// targets returns the targets read from the reader. With --align, all
+// targets are read and queued first, so that the prefixes are aligned
+// before the first line of output is shown.
+func (o *options) targets(
+	ctx context.Context,
+	rep *term.Report,
+	reader io.Reader,
+) <-chan ssh.Target {
+	targets := o.builder.Targets(ctx, reader)
+	if !*o.align {
+		return targets
+	}
+
+	collected := make([]ssh.Target, 0)
+	for target := range targets {
+		collected = append(collected, target)
+	}
+
+	return queueTargets(ctx, rep, collected)
+}
+
+// queueTargets queues the given targets in the report, and returns a
+// channel yielding them.
+func queueTargets(
+	ctx context.Context,
+	rep *term.Report,
+	targets []ssh.Target,
+) <-chan ssh.Target {
+	for _, target := range targets {
+		rep.Queue(target.Server)
+	}
+
+	ch := make(chan ssh.Target)
+	go func() {
+		defer close(ch)
+		for _, target := range targets {
+			if !stream.ContextSend(ctx, ch, target) {
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// runner returns a plan runner using the options and the given report.
+func (o *options) runner(rep *term.Report) *plan.Runner {
+	return &plan.Runner{
+		Builder: &o.builder,
+		Workers: uint(*o.workers),
+		Report:  rep,
+		Raw:     *o.raw,
+	}
+}
+
+// run runs the plan on the targets, and shows the dashboard while doing
+// so, if enabled. The report is only shown once the dashboard is closed,
+// followed by a summary.
+func (o *options) run(
+	ctx context.Context,
+	rep *term.Report,
+	p *plan.Plan,
+	targets <-chan ssh.Target,
+) {
+	runner := o.runner(rep)
+
+	if !*o.tui {
+		runner.Run(ctx, p, targets)
+		return
+	}
+
+	stdout, stderr := rep.Stdout, rep.Stderr
+	rep.Stdout, rep.Stderr = io.Discard, io.Discard
+
+	ctx, quit := context.WithCancel(ctx)
+	defer quit()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runner.Run(ctx, p, targets)
+	}()
+
+	dashboard := term.Dashboard{
+		Report: rep,
+		Cancel: runner.Cancel,
+		Quit:   quit,
+	}
+
+	if err := dashboard.Show(done); err != nil {
+		fmt.Fprintln(stderr, "Could not show dashboard:", err)
+		<-done
+	}
+
+	rep.Stdout, rep.Stderr = stdout, stderr
+	fmt.Fprintln(stderr, rep.Summary())
+}
+
+// close releases the resources acquired during setup, like master
+// connections. Must be called before exiting.
+func (o *options) close() {
+	if o.builder.Control == nil {
+		return
+	}
+
+	if err := o.builder.Control.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not close master connections:", err)
+	}
+}
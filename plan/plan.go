@@ -0,0 +1,191 @@
+// Package plan describes what is run on each server: Either a single
+// command, or multiple steps, run one after the other, as long as the
+// previous step was successful.
+package plan
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OnFailure defines what happens on a server after a step failed.
+type OnFailure string
+
+const (
+	// Stop skips the remaining steps on the server, default.
+	Stop OnFailure = "stop"
+
+	// Continue runs the next step on the server, as if the step succeeded.
+	Continue OnFailure = "continue"
+)
+
+// Step is a single command run on each server.
+type Step struct {
+	// Name is shown with the output of the step.
+	Name string
+
+	// Command to execute.
+	Command string
+
+	// Timeout after which the command is aborted (and considered failed),
+	// counted from the moment it is started. If zero, there is no timeout.
+	Timeout time.Duration
+
+	// SuccessCodes are the exit codes considered successful. If empty,
+	// only 0 is considered successful.
+	SuccessCodes []int
+
+	// OnFailure defines what happens after this step failed.
+	OnFailure OnFailure
+
+	// Guard is true if the step decides whether the server is used at all.
+	// If a guard fails, the server is skipped, which is not considered to
+	// be a failure.
+	Guard bool
+}
+
+// Plan is a list of steps run on each server.
+type Plan struct {
+	Steps []Step
+}
+
+// Single returns a plan with a single, unnamed step.
+func Single(command string) *Plan {
+	return &Plan{Steps: []Step{{Command: command, OnFailure: Stop}}}
+}
+
+// WithGuard returns a copy of the plan, with a guard step running the given
+// command before all other steps.
+func (p *Plan) WithGuard(command string) *Plan {
+	guard := Step{
+		Name:      "guard",
+		Command:   command,
+		OnFailure: Stop,
+		Guard:     true,
+	}
+
+	return &Plan{Steps: append([]Step{guard}, p.Steps...)}
+}
+
+// Names returns the names of the steps shown in the output (see
+// term.Origin), which excludes guards and unnamed steps.
+func (p *Plan) Names() []string {
+	names := make([]string, 0, len(p.Steps))
+	for _, step := range p.Steps {
+		if !step.Guard && step.Name != "" {
+			names = append(names, step.Name)
+		}
+	}
+
+	return names
+}
+
+// Succeeded returns true if the given exit code is considered successful.
+func (s *Step) Succeeded(code int) bool {
+	if len(s.SuccessCodes) == 0 {
+		return code == 0
+	}
+
+	for _, success := range s.SuccessCodes {
+		if code == success {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stepsFile is the YAML representation of a plan.
+type stepsFile struct {
+	Steps []struct {
+		Name         string `yaml:"name"`
+		Command      string `yaml:"command"`
+		Timeout      string `yaml:"timeout"`
+		SuccessCodes []int  `yaml:"success_codes"`
+		OnFailure    string `yaml:"on_failure"`
+	} `yaml:"steps"`
+}
+
+// Load reads a plan from a YAML file (see Parse).
+func Load(file string) (*Plan, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return p, nil
+}
+
+// Parse reads a plan from YAML like this:
+//
+//	steps:
+//	  - name: stop
+//	    command: systemctl stop app
+//	    timeout: 30s
+//	  - name: migrate
+//	    command: app migrate
+//	    success_codes: [0, 3]
+//	    on_failure: continue
+//
+// Only the command is required. Steps without a name are named after their
+// position ("step 1", "step 2", ...).
+func Parse(data []byte) (*Plan, error) {
+	var file stepsFile
+
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	if len(file.Steps) == 0 {
+		return nil, fmt.Errorf("no steps defined")
+	}
+
+	p := &Plan{Steps: make([]Step, 0, len(file.Steps))}
+	for ix, s := range file.Steps {
+		step := Step{
+			Name:         s.Name,
+			Command:      s.Command,
+			SuccessCodes: s.SuccessCodes,
+			OnFailure:    OnFailure(s.OnFailure),
+		}
+
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step %d", ix+1)
+		}
+
+		if step.Command == "" {
+			return nil, fmt.Errorf("%s: no command", step.Name)
+		}
+
+		if s.Timeout != "" {
+			timeout, err := time.ParseDuration(s.Timeout)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf(
+					"%s: invalid timeout %q", step.Name, s.Timeout)
+			}
+			step.Timeout = timeout
+		}
+
+		switch step.OnFailure {
+		case "":
+			step.OnFailure = Stop
+		case Stop, Continue:
+		default:
+			return nil, fmt.Errorf(
+				"%s: invalid on_failure %q (use stop or continue)",
+				step.Name, s.OnFailure)
+		}
+
+		p.Steps = append(p.Steps, step)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,78 @@
+package plan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	p, err := Parse([]byte(`
+steps:
+  - name: stop
+    command: systemctl stop app
+    timeout: 30s
+  - command: app migrate
+    success_codes: [0, 3]
+    on_failure: continue
+`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Plan{Steps: []Step{
+		{
+			Name:      "stop",
+			Command:   "systemctl stop app",
+			Timeout:   30 * time.Second,
+			OnFailure: Stop,
+		},
+		{
+			Name:         "step 2",
+			Command:      "app migrate",
+			SuccessCodes: []int{0, 3},
+			OnFailure:    Continue,
+		},
+	}}, p)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		``,
+		`steps: []`,
+		`steps: [{name: foo}]`,
+		`steps: [{command: foo, timeout: 10}]`,
+		`steps: [{command: foo, timeout: -1s}]`,
+		`steps: [{command: foo, on_failure: retry}]`,
+		`steps: {command: foo}`,
+	} {
+		_, err := Parse([]byte(text))
+		assert.Error(t, err, text)
+	}
+}
+
+func TestStepSucceeded(t *testing.T) {
+	step := Step{}
+	assert.True(t, step.Succeeded(0))
+	assert.False(t, step.Succeeded(1))
+
+	step.SuccessCodes = []int{1, 2}
+	assert.False(t, step.Succeeded(0))
+	assert.True(t, step.Succeeded(1))
+	assert.True(t, step.Succeeded(2))
+}
+
+func TestWithGuard(t *testing.T) {
+	p := Single("uptime")
+	guarded := p.WithGuard("test -f /etc/app.conf")
+
+	assert.Len(t, p.Steps, 1)
+	assert.Equal(t, []Step{
+		{
+			Name:      "guard",
+			Command:   "test -f /etc/app.conf",
+			OnFailure: Stop,
+			Guard:     true,
+		},
+		{Command: "uptime", OnFailure: Stop},
+	}, guarded.Steps)
+}
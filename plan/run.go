@@ -0,0 +1,328 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/href/ssh-each/ssh"
+	"github.com/href/ssh-each/stream"
+	"github.com/href/ssh-each/term"
+)
+
+// Runner runs plans on servers, using a stream.Mux.
+type Runner struct {
+	// Builder builds the commands of each step.
+	Builder *ssh.CommandBuilder
+
+	// Workers is the number of commands run concurrently.
+	Workers uint
+
+	// Report receives the results of all commands.
+	Report *term.Report
+
+	// Raw is true if output is passed on as it is written, instead of
+	// line by line (see stream.StreamRawCommand).
+	Raw bool
+
+	// cancels holds the cancel function of each target that is not done
+	mu      sync.Mutex
+	cancels map[string]context.CancelCauseFunc
+}
+
+// errCancelled is the cause of targets cancelled using Cancel.
+var errCancelled = errors.New("cancelled")
+
+// errTimedOut is the cause of steps that did not finish within their
+// timeout.
+var errTimedOut = errors.New("timed out")
+
+// progress tracks the step a target is at.
+type progress struct {
+	target ssh.Target
+	step   int
+
+	// ctx and cancel belong to the target, and stop all its steps
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	// stepCtx and stepCancel belong to the command of the current step
+	stepCtx    context.Context
+	stepCancel context.CancelCauseFunc
+
+	// timer cancels the current step once its timeout is over, counted
+	// from the moment the command is started
+	timer *time.Timer
+}
+
+// startTimer starts the timeout of the current step, as its command is
+// started (i.e. not while it waits for a worker).
+func (t *progress) startTimer(step *Step) {
+	if step.Timeout <= 0 || t.timer != nil {
+		return
+	}
+
+	cancel := t.stepCancel
+	t.timer = time.AfterFunc(step.Timeout, func() {
+		cancel(errTimedOut)
+	})
+}
+
+// stopStep stops the timer and releases the context of the current step.
+func (t *progress) stopStep() {
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+
+	t.stepCancel(nil)
+}
+
+// Cancel stops the command running on the given server, while Run is
+// running. The remaining steps of the server are skipped as well. Returns
+// false if the server is not known, or already done.
+func (r *Runner) Cancel(server string) bool {
+	r.mu.Lock()
+	cancel, ok := r.cancels[server]
+	r.mu.Unlock()
+
+	if ok {
+		cancel(errCancelled)
+	}
+
+	return ok
+}
+
+// track creates the context of the given target, which can be cancelled
+// using Cancel until the target is released.
+func (r *Runner) track(ctx context.Context, t *progress) {
+	t.ctx, t.cancel = context.WithCancelCause(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cancels == nil {
+		r.cancels = make(map[string]context.CancelCauseFunc)
+	}
+	r.cancels[t.target.Server] = t.cancel
+}
+
+// release frees the context of the given target once it is done, and lets
+// the report know.
+func (r *Runner) release(t *progress) {
+	r.mu.Lock()
+	delete(r.cancels, t.target.Server)
+	r.mu.Unlock()
+
+	t.cancel(nil)
+	r.Report.Done(t.target.Server)
+}
+
+// Run runs the plan on each target as it is received. Each target runs the
+// steps in order, independent of the other targets. Run returns once all
+// targets are done, or the context is cancelled.
+func (r *Runner) Run(
+	ctx context.Context,
+	p *Plan,
+	targets <-chan ssh.Target,
+) {
+	newMux := stream.NewMux
+	if r.Raw {
+		newMux = stream.NewRawMux
+	}
+	mux := newMux(ctx, r.Workers)
+
+	// Targets that have steps left, by the command of their current step
+	mu := sync.Mutex{}
+	running := make(map[*exec.Cmd]*progress)
+	pending := 0
+	reading := true
+	shut := false
+
+	// Stops accepting new commands once no more are expected, which causes
+	// the Results channel to be closed. Must be called with the lock held.
+	shutIfDone := func() {
+		if !reading && pending == 0 && !shut {
+			shut = true
+			mux.Shut()
+		}
+	}
+
+	// Submits the current step of the target. May block while all workers
+	// are busy, so it must not be called by the receiver of the results.
+	submit := func(t *progress) {
+		step := &p.Steps[t.step]
+
+		t.stepCtx, t.stepCancel = context.WithCancelCause(t.ctx)
+
+		cmd := r.command(t.stepCtx, step, t.target)
+		r.Report.AssociateOrigin(cmd, r.origin(p, t))
+
+		mu.Lock()
+		running[cmd] = t
+		mu.Unlock()
+
+		if !mux.Submit(cmd) {
+			mu.Lock()
+			delete(running, cmd)
+			pending--
+			mu.Unlock()
+			t.stopStep()
+			r.release(t)
+		}
+	}
+
+	// Targets are read as soon as they are available, so that the report
+	// knows about the servers waiting for a worker
+	queue := make([]*progress, 0)
+	queued := sync.NewCond(&mu)
+
+	go func() {
+		for target := range targets {
+			r.Report.Queue(target.Server)
+
+			t := &progress{target: target}
+			r.track(ctx, t)
+
+			mu.Lock()
+			pending++
+			queue = append(queue, t)
+			queued.Signal()
+			mu.Unlock()
+		}
+
+		mu.Lock()
+		reading = false
+		queued.Signal()
+		shutIfDone()
+		mu.Unlock()
+	}()
+
+	go func() {
+		for {
+			mu.Lock()
+			for len(queue) == 0 && reading {
+				queued.Wait()
+			}
+
+			if len(queue) == 0 {
+				mu.Unlock()
+				return
+			}
+
+			t := queue[0]
+			queue = queue[1:]
+			mu.Unlock()
+
+			submit(t)
+		}
+	}()
+
+	for result := range mux.Results() {
+		r.Report.On(result)
+
+		switch result.Result.Type() {
+		case stream.StartResult:
+			mu.Lock()
+			t := running[result.Command]
+			mu.Unlock()
+
+			if t != nil {
+				t.startTimer(&p.Steps[t.step])
+			}
+			continue
+		case stream.ExitResult, stream.ErrorResult:
+		default:
+			continue
+		}
+
+		mu.Lock()
+		t := running[result.Command]
+		delete(running, result.Command)
+		mu.Unlock()
+
+		if t == nil {
+			continue
+		}
+
+		if r.advance(p, t, result) {
+			go submit(t)
+			continue
+		}
+
+		r.release(t)
+
+		mu.Lock()
+		pending--
+		shutIfDone()
+		mu.Unlock()
+	}
+}
+
+// command builds the command of the given step for the target.
+func (r *Runner) command(
+	ctx context.Context,
+	step *Step,
+	target ssh.Target,
+) *exec.Cmd {
+	builder := *r.Builder
+	builder.Command = step.Command
+
+	return builder.For(ctx, target.Destination)
+}
+
+// origin returns the origin of the target's current step.
+func (r *Runner) origin(p *Plan, t *progress) term.Origin {
+	step := &p.Steps[t.step]
+
+	origin := term.Origin{
+		Server:  t.target.Server,
+		Success: step.Succeeded,
+		Guard:   step.Guard,
+	}
+
+	if !step.Guard {
+		origin.Step = step.Name
+	}
+
+	return origin
+}
+
+// advance moves the target to its next step, if the result of its current
+// step allows it. Returns false if the target is done.
+func (r *Runner) advance(
+	p *Plan,
+	t *progress,
+	result stream.CommandResult,
+) bool {
+	step := &p.Steps[t.step]
+	t.stopStep()
+
+	if errors.Is(context.Cause(t.ctx), errCancelled) {
+		r.Report.Notice(result.Command, "cancelled")
+		return false
+	}
+
+	// The command could not be run
+	if result.Result.Type() == stream.ErrorResult {
+		return false
+	}
+
+	succeeded := step.Succeeded(result.Result.ExitCode())
+
+	if errors.Is(context.Cause(t.stepCtx), errTimedOut) {
+		succeeded = false
+		r.Report.Notice(result.Command,
+			fmt.Sprintf("timed out after %s", step.Timeout))
+	}
+
+	if !succeeded && step.OnFailure != Continue {
+		return false
+	}
+
+	t.step++
+	return t.step < len(p.Steps)
+}
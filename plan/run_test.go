@@ -0,0 +1,178 @@
+package plan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/href/ssh-each/ssh"
+	"github.com/href/ssh-each/term"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSSH puts an ssh command on the PATH, that runs the given command
+// locally, with $HOST set to the destination.
+func fakeSSH(t *testing.T) {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+for last; do :; done
+for host; do [ "$host" = "$last" ] && break; prev=$host; done
+HOST=$prev exec sh -c "$last"
+`
+	file := filepath.Join(dir, "ssh")
+	assert.NoError(t, os.WriteFile(file, []byte(script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// targetsOf returns a channel yielding a target for each server.
+func targetsOf(servers ...string) <-chan ssh.Target {
+	ch := make(chan ssh.Target)
+	go func() {
+		defer close(ch)
+		for _, server := range servers {
+			ch <- ssh.Target{
+				Destination: *ssh.ParseDestination(server),
+				Server:      server,
+			}
+		}
+	}()
+	return ch
+}
+
+func TestRunner(t *testing.T) {
+	fakeSSH(t)
+
+	log := filepath.Join(t.TempDir(), "log")
+	p := &Plan{Steps: []Step{
+		{Name: "one", Command: "echo one $HOST >> " + log, OnFailure: Stop},
+		{Name: "two", Command: `[ "$HOST" != b ]`, OnFailure: Stop},
+		{Name: "three", Command: `[ "$HOST" != c ]`, OnFailure: Continue},
+		{Name: "four", Command: "echo four $HOST >> " + log, OnFailure: Stop},
+		{
+			Name:      "five",
+			Command:   "exec sleep 5",
+			Timeout:   50 * time.Millisecond,
+			OnFailure: Stop,
+		},
+		{Name: "six", Command: "echo six $HOST >> " + log, OnFailure: Stop},
+	}}
+
+	ch := targetsOf("a", "b", "c")
+
+	rep := term.NewReport(term.SilentReport)
+	runner := Runner{Builder: &ssh.CommandBuilder{}, Workers: 2, Report: &rep}
+	runner.Run(context.Background(), p, ch)
+
+	assert.False(t, rep.Success())
+
+	output, err := os.ReadFile(log)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	sort.Strings(lines)
+
+	assert.Equal(t, []string{
+		"four a",
+		"four c",
+		"one a",
+		"one b",
+		"one c",
+	}, lines)
+}
+
+func TestRunnerTimeoutQueued(t *testing.T) {
+	fakeSSH(t)
+
+	// The timeout starts once a worker runs the command, so waiting for the
+	// single worker does not count against it
+	p := &Plan{Steps: []Step{{
+		Name:      "sleep",
+		Command:   "sleep 0.1",
+		Timeout:   200 * time.Millisecond,
+		OnFailure: Stop,
+	}}}
+
+	rep := term.NewReport(term.SilentReport)
+	runner := Runner{Builder: &ssh.CommandBuilder{}, Workers: 1, Report: &rep}
+	runner.Run(context.Background(), p, targetsOf("a", "b", "c", "d"))
+
+	assert.True(t, rep.Success())
+	for _, outcome := range rep.Outcomes() {
+		assert.Equal(t, term.Succeeded, outcome.State(), outcome.Server)
+	}
+}
+
+func TestRunnerGuard(t *testing.T) {
+	fakeSSH(t)
+
+	log := filepath.Join(t.TempDir(), "log")
+	p := Single("echo $HOST >> " + log).WithGuard(`[ "$HOST" != b ]`)
+
+	ch := targetsOf("a", "b")
+
+	rep := term.NewReport(term.SilentReport)
+	runner := Runner{Builder: &ssh.CommandBuilder{}, Workers: 2, Report: &rep}
+	runner.Run(context.Background(), p, ch)
+
+	assert.True(t, rep.Success())
+	assert.Equal(t, []string{"b"}, rep.Skipped())
+
+	output, err := os.ReadFile(log)
+	assert.NoError(t, err)
+	assert.Equal(t, "a\n", string(output))
+}
+
+func TestRunnerGuardUnreachable(t *testing.T) {
+	fakeSSH(t)
+
+	// ssh exits with 255 if it cannot connect, which is not a skip
+	p := Single("true").WithGuard(`[ "$HOST" != b ] || exit 255`)
+
+	rep := term.NewReport(term.SilentReport)
+	runner := Runner{Builder: &ssh.CommandBuilder{}, Workers: 2, Report: &rep}
+	runner.Run(context.Background(), p, targetsOf("a", "b"))
+
+	assert.False(t, rep.Success())
+	assert.Empty(t, rep.Skipped())
+
+	outcomes := rep.Outcomes()
+	assert.Equal(t, "b", outcomes[1].Server)
+	assert.Equal(t, term.Failed, outcomes[1].State())
+	assert.Equal(t, 255, outcomes[1].ExitCode)
+}
+
+func TestRunnerCancel(t *testing.T) {
+	fakeSSH(t)
+
+	log := filepath.Join(t.TempDir(), "log")
+	p := &Plan{Steps: []Step{
+		{Name: "one", Command: `[ "$HOST" != a ] || exec sleep 5`},
+		{Name: "two", Command: "echo two $HOST >> " + log},
+	}}
+
+	rep := term.NewReport(term.SilentReport)
+	runner := Runner{Builder: &ssh.CommandBuilder{}, Workers: 2, Report: &rep}
+
+	go func() {
+		for !runner.Cancel("a") {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	runner.Run(context.Background(), p, targetsOf("a", "b"))
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	output, err := os.ReadFile(log)
+	assert.NoError(t, err)
+	assert.Equal(t, "two b\n", string(output))
+
+	assert.False(t, runner.Cancel("a"))
+	// Depending on whether a was started already, it failed or errored
+	assert.Contains(t,
+		[]term.State{term.Failed, term.Errored}, rep.Outcomes()[0].State())
+}
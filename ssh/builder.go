@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"context"
 	"io"
+	"math/rand"
+	"os"
 	"os/exec"
+	"os/user"
 	"strconv"
 	"strings"
 )
@@ -27,6 +30,37 @@ type CommandBuilder struct {
 
 	// Command to be executed each time.
 	Command string
+
+	// Selector limits the destinations read by Targets to the ones whose
+	// labels match. If nil, all destinations are used.
+	Selector *Selector
+
+	// Exclude skips the destinations read by Targets that it matches. If
+	// nil, no destinations are excluded.
+	Exclude *Exclusion
+
+	// Duplicate is called by Targets for each server that is skipped,
+	// because it refers to the same destination as a server read before it
+	// (e.g. "web1" and "WEB1:22"). If nil, duplicates are skipped silently.
+	Duplicate func(server string, original string)
+
+	// Shuffle randomizes the order of the destinations read by Targets.
+	// Note that this requires all destinations to be read before the first
+	// command is built.
+	Shuffle bool
+
+	// Sample limits the destinations read by Targets to a random subset.
+	// Like Shuffle, this requires all destinations to be read first. If nil,
+	// all destinations are used.
+	Sample *Sample
+
+	// Rand is used to shuffle and sample destinations. If nil, a randomly
+	// seeded source is used.
+	Rand *rand.Rand
+
+	// Control makes commands share a master connection per destination. If
+	// nil, each command opens its own connection.
+	Control *ControlMaster
 }
 
 // LinkedCommand is a command linked to a server
@@ -35,40 +69,96 @@ type LinkedCommand struct {
 	Server  string
 }
 
+// Target is a destination read by Targets, with the server it was read as.
+type Target struct {
+	Destination Destination
+	Server      string
+}
+
 // For creates a command for the given destination
 func (o *CommandBuilder) For(ctx context.Context, dst Destination) *exec.Cmd {
 	// We'll heave at least "ssh", a host, and a command.
 	args := make([]string, 0, 3)
 	args = append(args, "ssh")
 
-	switch {
-	case dst.Port > 0:
-		args = append(args, "-p", strconv.Itoa(int(dst.Port)))
-	case o.ExplicitPort > 0:
-		args = append(args, "-p", strconv.Itoa(int(o.ExplicitPort)))
+	if o.Control != nil {
+		args = append(args, o.Control.Options()...)
+		o.Control.register(o.connection(dst))
 	}
 
+	args = append(args, o.port(dst)...)
+
 	// If a TTY is wanted, use the '-tt' variant, as the weaker '-t' variant
 	// won't work since we are not forwarding STDIN
 	if o.TTY {
 		args = append(args, "-tt")
 	}
 
+	args = append(args, o.user(dst)...)
+
+	// Finally, we add the host and the command
+	args = append(args, dst.StringWithoutPort(), o.Command)
+
+	return exec.CommandContext(ctx, args[0], args[1:]...)
+}
+
+// connection returns the arguments identifying the connection to the given
+// destination (port, user and host).
+func (o *CommandBuilder) connection(dst Destination) []string {
+	args := append(o.port(dst), o.user(dst)...)
+	return append(args, dst.StringWithoutPort())
+}
+
+// port returns the port argument for the given destination, if any.
+func (o *CommandBuilder) port(dst Destination) []string {
+	switch {
+	case dst.Port > 0:
+		return []string{"-p", strconv.Itoa(int(dst.Port))}
+	case o.ExplicitPort > 0:
+		return []string{"-p", strconv.Itoa(int(o.ExplicitPort))}
+	default:
+		return nil
+	}
+}
+
+// user returns the user argument for the given destination, if any.
+func (o *CommandBuilder) user(dst Destination) []string {
 	// If the destination has a user, we don't need to set it anywhere, as
 	// it will be rendered as "user@host", which has precedence over everything
 	// else.
 	if dst.User == "" && o.ExplicitUser != "" {
-		args = append(args, "-l", o.ExplicitUser)
+		return []string{"-l", o.ExplicitUser}
 	}
 
-	// Finally, we add the host and the command
-	args = append(args, dst.StringWithoutPort(), o.Command)
+	return nil
+}
 
-	return exec.CommandContext(ctx, args[0], args[1:]...)
+// Normalize returns the destination with the defaults of the builder (or
+// of the current user) applied, which is used to detect duplicates. Note
+// that this does not consider defaults set in the SSH config.
+func (o *CommandBuilder) Normalize(dst Destination) Destination {
+	name := dst.User
+	if name == "" {
+		name = o.ExplicitUser
+	}
+	if name == "" {
+		name = currentUser()
+	}
+
+	return dst.Normalized(name, o.ExplicitPort)
+}
+
+// currentUser returns the name of the current user, if known.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
 }
 
-// FromReader builds commands for the servers read from the reader. Each
-// line is expected to include a single server.
+// FromReader builds commands for the servers read from the reader (see
+// Targets).
 func (o *CommandBuilder) FromReader(
 	ctx context.Context,
 	r io.Reader,
@@ -78,28 +168,135 @@ func (o *CommandBuilder) FromReader(
 	go func() {
 		defer close(ch)
 
-		scanner := bufio.NewScanner(r)
-		for scanner.Scan() {
-			server := scanner.Text()
-			server = strings.Trim(server, " \n")
+		for target := range o.Targets(ctx, r) {
+			linked := LinkedCommand{
+				Command: o.For(ctx, target.Destination),
+				Server:  target.Server,
+			}
 
-			if server == "" {
-				continue
+			if !send(ctx, ch, linked) {
+				return
 			}
+		}
+	}()
 
-			linked := LinkedCommand{
-				Command: o.For(ctx, *ParseDestination(server)),
-				Server:  server,
+	return ch
+}
+
+// send sends the item to the channel, or returns false if the context is
+// done before.
+func send[T any](ctx context.Context, ch chan<- T, item T) bool {
+	select {
+	case ch <- item:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Targets reads the servers from the reader. Each line is expected to
+// include a single server, optionally followed by labels (e.g.
+// "host#role=web,dc=ams"). Servers not matched by the selector, or matched
+// by the exclusion are skipped, as are duplicate destinations.
+//
+// If Shuffle or Sample are used, the targets are only sent once the reader
+// is exhausted. Otherwise they are sent as soon as they are read.
+func (o *CommandBuilder) Targets(
+	ctx context.Context,
+	r io.Reader,
+) <-chan Target {
+	ch := make(chan Target)
+
+	go func() {
+		defer close(ch)
+
+		// Without shuffling or sampling, stream the targets as they come
+		if !o.Shuffle && o.Sample == nil {
+			o.readTargets(ctx, r, func(target Target) bool {
+				return send(ctx, ch, target)
+			})
+			return
+		}
+
+		targets := make([]Target, 0)
+		o.readTargets(ctx, r, func(target Target) bool {
+			targets = append(targets, target)
+			return true
+		})
+
+		rnd := o.Rand
+		if rnd == nil {
+			rnd = rand.New(rand.NewSource(rand.Int63()))
+		}
+
+		if o.Sample != nil {
+			picked := make([]Target, 0, o.Sample.Size(len(targets)))
+			for _, ix := range o.Sample.Pick(rnd, len(targets)) {
+				picked = append(picked, targets[ix])
 			}
+			targets = picked
+		}
+
+		if o.Shuffle {
+			rnd.Shuffle(len(targets), func(i, j int) {
+				targets[i], targets[j] = targets[j], targets[i]
+			})
+		}
 
-			select {
-			case ch <- linked:
-				continue
-			case <-ctx.Done():
-				break
+		for _, target := range targets {
+			if !send(ctx, ch, target) {
+				return
 			}
 		}
 	}()
 
 	return ch
 }
+
+// readTargets reads the targets from the reader, passing each to the given
+// function, until the reader is exhausted or the function returns false.
+func (o *CommandBuilder) readTargets(
+	ctx context.Context,
+	r io.Reader,
+	fn func(Target) bool,
+) {
+	// Normalized destinations to the servers they were first read as
+	seen := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		line := strings.Trim(scanner.Text(), " \n")
+
+		dst := ParseDestination(line)
+		if dst == nil {
+			continue
+		}
+
+		if o.Selector != nil && !o.Selector.Match(*dst) {
+			continue
+		}
+
+		if o.Exclude != nil && o.Exclude.Match(*dst) {
+			continue
+		}
+
+		server, _ := SplitLabels(line)
+
+		key := o.Normalize(*dst).String()
+		if original, ok := seen[key]; ok {
+			if o.Duplicate != nil {
+				o.Duplicate(server, original)
+			}
+			continue
+		}
+		seen[key] = server
+
+		if !fn(Target{Destination: *dst, Server: server}) {
+			return
+		}
+	}
+}
@@ -2,6 +2,8 @@ package ssh
 
 import (
 	"context"
+	"math/rand"
+	"sort"
 	"strings"
 	"testing"
 
@@ -68,3 +70,138 @@ func TestCommandBuilderFromReader(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestCommandBuilderFromReaderSelector(t *testing.T) {
+	reader := strings.NewReader("host1#role=web\nhost2#role=db\nhost3")
+
+	selector, err := ParseSelector("role=web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cb := CommandBuilder{Command: "whoami", Selector: selector}
+	ch := cb.FromReader(context.Background(), reader)
+
+	produced := []LinkedCommand{}
+	for cmd := range ch {
+		produced = append(produced, cmd)
+	}
+
+	if len(produced) != 1 {
+		t.Fatalf("expected one command, got %d", len(produced))
+	}
+
+	if produced[0].Server != "host1" {
+		t.Errorf("unexpected server %q", produced[0].Server)
+	}
+
+	expected := []string{"ssh", "host1", "whoami"}
+	if diff := deep.Equal(expected, produced[0].Command.Args); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCommandBuilderFromReaderDuplicates(t *testing.T) {
+	reader := strings.NewReader(
+		"host1\nHOST1:22\nadmin@host1\nhost1:2222\nroot@host2\nhost2#a=b")
+
+	duplicates := [][]string{}
+	cb := CommandBuilder{
+		Command:      "whoami",
+		ExplicitUser: "root",
+		Duplicate: func(server string, original string) {
+			duplicates = append(duplicates, []string{server, original})
+		},
+	}
+
+	produced := []string{}
+	for cmd := range cb.FromReader(context.Background(), reader) {
+		produced = append(produced, cmd.Server)
+	}
+
+	expected := []string{"host1", "admin@host1", "host1:2222", "root@host2"}
+	if diff := deep.Equal(expected, produced); diff != nil {
+		t.Error(diff)
+	}
+
+	expectedDuplicates := [][]string{
+		{"HOST1:22", "host1"},
+		{"host2", "root@host2"},
+	}
+	if diff := deep.Equal(expectedDuplicates, duplicates); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCommandBuilderTargetsShuffleSample(t *testing.T) {
+	servers := func(cb CommandBuilder) []string {
+		reader := strings.NewReader("a\nb\nc\nd\ne\nf\ng\nh")
+
+		produced := []string{}
+		for target := range cb.Targets(context.Background(), reader) {
+			produced = append(produced, target.Server)
+		}
+		return produced
+	}
+
+	all := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	shuffled := servers(CommandBuilder{
+		Shuffle: true,
+		Rand:    rand.New(rand.NewSource(1)),
+	})
+	if deep.Equal(all, shuffled) == nil {
+		t.Error("expected servers to be shuffled")
+	}
+
+	sort.Strings(shuffled)
+	if diff := deep.Equal(all, shuffled); diff != nil {
+		t.Error(diff)
+	}
+
+	sampled := servers(CommandBuilder{
+		Sample: &Sample{Percent: 50},
+		Rand:   rand.New(rand.NewSource(1)),
+	})
+	if len(sampled) != 4 {
+		t.Errorf("expected 4 servers, got %d", len(sampled))
+	}
+	if !sort.StringsAreSorted(sampled) {
+		t.Errorf("expected sample to keep the input order: %v", sampled)
+	}
+}
+
+func TestCommandBuilderControlMaster(t *testing.T) {
+	control, err := NewControlMaster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer control.Close()
+
+	cb := CommandBuilder{Command: "uptime", ExplicitUser: "root"}
+	cb.Control = control
+
+	cmd := cb.For(context.Background(), Destination{Host: "host", Port: 22})
+	path := "ControlPath=" + control.Dir() + "/%C"
+
+	expected := []string{
+		"ssh",
+		"-o", "ControlMaster=auto",
+		"-o", path,
+		"-o", "ControlPersist=yes",
+		"-p", "22",
+		"-l", "root",
+		"host",
+		"uptime",
+	}
+	if diff := deep.Equal(cmd.Args, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	expectedConns := map[string][]string{
+		"-p\x0022\x00-l\x00root\x00host": {"-p", "22", "-l", "root", "host"},
+	}
+	if diff := deep.Equal(control.conns, expectedConns); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,187 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxIncludeDepth limits how deeply Include directives are followed, which
+// protects against include loops (OpenSSH uses the same limit).
+const maxIncludeDepth = 16
+
+// UserConfigPath returns the path of the OpenSSH user configuration file
+// (~/.ssh/config).
+func UserConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".ssh", "config"), nil
+}
+
+// ConfigHosts returns the concrete hosts found in the given OpenSSH config
+// file, in the order they are defined, following Include directives.
+//
+// Only Host entries without wildcards or negations are considered concrete,
+// as the others cannot be connected to directly. The hosts are filtered by
+// the given glob pattern (see path.Match), an empty pattern matches all.
+func ConfigHosts(file string, pattern string) ([]string, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	c := configReader{
+		dir:  filepath.Dir(file),
+		seen: make(map[string]bool),
+	}
+
+	if err := c.read(file, 0); err != nil {
+		return nil, err
+	}
+
+	hosts := make([]string, 0, len(c.hosts))
+	for _, host := range c.hosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts, nil
+}
+
+// configReader collects the hosts of an OpenSSH config and its includes.
+type configReader struct {
+	// dir is the directory relative includes are resolved against
+	dir string
+
+	// hosts found so far, without duplicates
+	hosts []string
+
+	// seen hosts, to avoid duplicates
+	seen map[string]bool
+}
+
+// read parses a single config file, recursing into included files.
+func (c *configReader) read(file string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s: too many nested includes", file)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		keyword, args := splitConfigLine(scanner.Text())
+
+		switch keyword {
+		case "host":
+			for _, host := range args {
+				if !isConcreteHost(host) || c.seen[host] {
+					continue
+				}
+
+				c.seen[host] = true
+				c.hosts = append(c.hosts, host)
+			}
+		case "include":
+			for _, arg := range args {
+				if err := c.include(arg, depth); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// include reads all files matching the given Include argument. Like OpenSSH
+// we accept globs, expand ~ and treat relative paths as relative to the
+// directory of the user config. Globs that match nothing are ignored.
+func (c *configReader) include(arg string, depth int) error {
+	if strings.HasPrefix(arg, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		arg = filepath.Join(home, arg[2:])
+	}
+
+	if !filepath.IsAbs(arg) {
+		arg = filepath.Join(c.dir, arg)
+	}
+
+	files, err := filepath.Glob(arg)
+	if err != nil {
+		return fmt.Errorf("invalid include %q: %w", arg, err)
+	}
+
+	for _, file := range files {
+		if err := c.read(file, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitConfigLine returns the lowercase keyword and the arguments of a
+// config line. Keyword and arguments may be separated by whitespace or a
+// single '=', arguments may be enclosed in double quotes.
+func splitConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end == -1 {
+		return strings.ToLower(line), nil
+	}
+
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	args := make([]string, 0)
+	var arg strings.Builder
+	quoted := false
+	for _, char := range rest {
+		switch {
+		case char == '"':
+			quoted = !quoted
+		case !quoted && (char == ' ' || char == '\t'):
+			if arg.Len() > 0 {
+				args = append(args, arg.String())
+				arg.Reset()
+			}
+		default:
+			arg.WriteRune(char)
+		}
+	}
+
+	if arg.Len() > 0 {
+		args = append(args, arg.String())
+	}
+
+	return keyword, args
+}
+
+// isConcreteHost returns true if the given Host pattern refers to a single
+// host, as opposed to a wildcard or negated pattern.
+func isConcreteHost(host string) bool {
+	return host != "" && !strings.ContainsAny(host, "*?!")
+}
@@ -0,0 +1,85 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigHosts(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, content string) string {
+		file := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0o700))
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+		return file
+	}
+
+	config := write("config", `
+		# Comment
+		Include conf.d/*.conf
+
+		Host prod-web1 prod-web2
+		  User deploy
+
+		Host prod-* !prod-db*
+		  Port 2222
+
+		Host=staging-web1
+		Host "prod-db1" prod-web1
+		Match host foo
+	`)
+
+	write("conf.d/a.conf", "Host prod-cache1\nInclude b.conf.inc\n")
+	write("b.conf.inc", "HOST prod-cache2 dev?\n")
+
+	assertHosts := func(pattern string, expected []string) {
+		hosts, err := ConfigHosts(config, pattern)
+		assert.NoError(t, err)
+
+		if diff := deep.Equal(hosts, expected); diff != nil {
+			t.Error(pattern, diff)
+		}
+	}
+
+	assertHosts("", []string{
+		"prod-cache1",
+		"prod-cache2",
+		"prod-web1",
+		"prod-web2",
+		"staging-web1",
+		"prod-db1",
+	})
+
+	assertHosts("prod-*", []string{
+		"prod-cache1",
+		"prod-cache2",
+		"prod-web1",
+		"prod-web2",
+		"prod-db1",
+	})
+
+	assertHosts("*-web?", []string{
+		"prod-web1",
+		"prod-web2",
+		"staging-web1",
+	})
+
+	_, err := ConfigHosts(config, "[")
+	assert.Error(t, err)
+
+	_, err = ConfigHosts(filepath.Join(dir, "missing"), "")
+	assert.Error(t, err)
+}
+
+func TestConfigHostsIncludeLoop(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config")
+	assert.NoError(t, os.WriteFile(file, []byte("Include config\n"), 0o600))
+
+	_, err := ConfigHosts(file, "")
+	assert.Error(t, err)
+}
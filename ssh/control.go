@@ -0,0 +1,127 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// closeTimeout limits how long closing a single master connection may take.
+const closeTimeout = 5 * time.Second
+
+// socketBase is where the socket directories are created. The default
+// temporary directory is not used, as it may be too long for socket paths
+// (e.g. $TMPDIR on macOS).
+const socketBase = "/tmp"
+
+// maxSocketPath is the longest socket path supported by all platforms (104
+// bytes on macOS and BSD, including the terminating null byte).
+const maxSocketPath = 103
+
+// socketName is the length of the socket name, the hash of the connection
+// (%C), plus the temporary suffix ssh adds while creating the socket.
+const socketName = 40 + 17
+
+// ControlMaster manages OpenSSH master connections (see ControlMaster in
+// ssh_config), so that commands run on the same destination reuse a single
+// authenticated connection. The control sockets are kept in a private
+// temporary directory, and the connections are kept open until Close.
+type ControlMaster struct {
+	// dir holds the control sockets
+	dir string
+
+	// mu protects conns
+	mu *sync.Mutex
+
+	// conns holds the connection arguments of each destination used, keyed
+	// by the joined arguments, to close the connections later.
+	conns map[string][]string
+}
+
+// NewControlMaster creates the private directory for the control sockets.
+func NewControlMaster() (*ControlMaster, error) {
+	return newControlMaster(socketBase)
+}
+
+// newControlMaster creates the private directory for the control sockets in
+// the given base directory, if the socket paths fit within it.
+func newControlMaster(base string) (*ControlMaster, error) {
+	dir, err := os.MkdirTemp(base, "ssh-each-")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dir)+1+socketName > maxSocketPath {
+		_ = os.Remove(dir)
+		return nil, fmt.Errorf(
+			"control socket directory %s is too long for socket paths", dir)
+	}
+
+	return &ControlMaster{
+		dir:   dir,
+		mu:    &sync.Mutex{},
+		conns: make(map[string][]string),
+	}, nil
+}
+
+// Dir returns the directory holding the control sockets.
+func (c *ControlMaster) Dir() string {
+	return c.dir
+}
+
+// Options returns the SSH options needed to use a master connection. The
+// socket name is derived from the local host, remote host, port and user
+// (%C), so each destination gets its own master.
+func (c *ControlMaster) Options() []string {
+	return []string{
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=" + filepath.Join(c.dir, "%C"),
+		"-o", "ControlPersist=yes",
+	}
+}
+
+// register remembers the connection arguments of a destination (port, user
+// and host), so that the master connection can be closed later.
+func (c *ControlMaster) register(args []string) {
+	key := strings.Join(args, "\x00")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.conns[key]; !ok {
+		c.conns[key] = args
+	}
+}
+
+// Close stops all master connections and removes the socket directory.
+// Masters that cannot be stopped (e.g. because the connection failed in
+// the first place) are ignored.
+func (c *ControlMaster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, args := range c.conns {
+		wg.Add(1)
+		go func(args []string) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(
+				context.Background(), closeTimeout)
+			defer cancel()
+
+			exit := append([]string{"-O", "exit"}, c.Options()...)
+			exit = append(exit, args...)
+			_ = exec.CommandContext(ctx, "ssh", exit...).Run()
+		}(args)
+	}
+	wg.Wait()
+
+	c.conns = make(map[string][]string)
+	return os.RemoveAll(c.dir)
+}
@@ -0,0 +1,42 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlMasterClose(t *testing.T) {
+	control, err := NewControlMaster()
+	assert.NoError(t, err)
+
+	info, err := os.Stat(control.Dir())
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	assert.NoError(t, control.Close())
+
+	_, err = os.Stat(control.Dir())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestControlMasterSocketPath(t *testing.T) {
+	control, err := NewControlMaster()
+	assert.NoError(t, err)
+	defer control.Close()
+
+	assert.True(t, strings.HasPrefix(control.Dir(), "/tmp/ssh-each-"))
+
+	base := filepath.Join(t.TempDir(), strings.Repeat("x", maxSocketPath))
+	assert.NoError(t, os.Mkdir(base, 0o700))
+
+	_, err = newControlMaster(base)
+	assert.ErrorContains(t, err, "too long")
+
+	entries, err := os.ReadDir(base)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -3,14 +3,16 @@ package ssh
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 // Destination describes an SSH target with a hostname (mandatory), a user
-// (optional) and a port (optional, defaults to 22)
+// (optional), a port (optional, defaults to 22) and labels (optional)
 type Destination struct {
-	Host string
-	User string
-	Port uint16
+	Host   string
+	User   string
+	Port   uint16
+	Labels map[string]string
 }
 
 // String returns the host detination ([user@]host[:port]).
@@ -40,14 +42,79 @@ func (t Destination) StringWithoutPort() string {
 	}
 }
 
+// Normalized returns a copy of the destination with a lowercase host, and
+// with the given user and port set, unless they are already present. The
+// port defaults to 22, if neither is set.
+func (t Destination) Normalized(user string, port uint16) Destination {
+	t.Host = strings.ToLower(t.Host)
+
+	if t.User == "" {
+		t.User = user
+	}
+
+	if t.Port == 0 {
+		t.Port = port
+	}
+
+	if t.Port == 0 {
+		t.Port = 22
+	}
+
+	return t
+}
+
 // ParseDestination returns a destination from string, if not empty:
 // - An empty string returns nil
 // - An invalid port is ignored
+// - Labels may follow a '#' (see ParseLabels)
 func ParseDestination(text string) *Destination {
+	text, labels := SplitLabels(text)
 	if text == "" {
 		return nil
 	}
 
+	dst := parseDestination(text)
+	dst.Labels = labels
+	return dst
+}
+
+// SplitLabels splits the given text into the destination and its labels,
+// which are optionally found after a '#' (e.g. "host#role=web,dc=ams").
+func SplitLabels(text string) (string, map[string]string) {
+	ix := strings.IndexByte(text, '#')
+	if ix == -1 {
+		return text, nil
+	}
+
+	return strings.TrimSpace(text[:ix]), ParseLabels(text[ix+1:])
+}
+
+// ParseLabels parses comma separated labels in the form of key=value. Keys
+// without a value are set to an empty string. If there are no labels, nil
+// is returned.
+func ParseLabels(text string) map[string]string {
+	var labels map[string]string
+
+	for _, label := range strings.Split(text, ",") {
+		key, value, _ := strings.Cut(label, "=")
+		key = strings.TrimSpace(key)
+
+		if key == "" {
+			continue
+		}
+
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+
+		labels[key] = strings.TrimSpace(value)
+	}
+
+	return labels
+}
+
+// parseDestination implements ParseDestination for text without labels.
+func parseDestination(text string) *Destination {
 	// s -> position of @ in foo@bar
 	// e -> position of : in bar:baz
 	var s, e int = -1, -1
@@ -21,6 +21,15 @@ func TestParseDestination(t *testing.T) {
 	assert("bar:123", Destination{Host: "bar", Port: 123})
 	assert("bar:123123123", Destination{Host: "bar:123123123"})
 	assert("foo:bar", Destination{Host: "foo:bar"})
+	assert("foo@bar:123#role=web,dc=ams", Destination{
+		User: "foo", Host: "bar", Port: 123,
+		Labels: map[string]string{"role": "web", "dc": "ams"},
+	})
+	assert("bar #canary, role = db", Destination{
+		Host:   "bar",
+		Labels: map[string]string{"canary": "", "role": "db"},
+	})
+	assert("bar#", Destination{Host: "bar"})
 }
 
 func TestFormatDestination(t *testing.T) {
@@ -36,3 +45,20 @@ func TestFormatDestination(t *testing.T) {
 	assert(Destination{User: "foo", Host: "bar"}, "foo@bar")
 	assert(Destination{User: "foo", Host: "bar", Port: 22}, "foo@bar:22")
 }
+
+func TestNormalizedDestination(t *testing.T) {
+	assert := func(input Destination, expected string) {
+		normalized := input.Normalized("user", 0).String()
+
+		if normalized != expected {
+			t.Errorf("%v: %s normalized, expected %s", input, normalized, expected)
+		}
+	}
+
+	assert(Destination{Host: "Foo"}, "user@foo:22")
+	assert(Destination{User: "root", Host: "foo", Port: 2222}, "root@foo:2222")
+
+	if dst := (Destination{Host: "foo"}).Normalized("", 2222); dst.Port != 2222 {
+		t.Errorf("expected port 2222, got %d", dst.Port)
+	}
+}
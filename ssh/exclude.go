@@ -0,0 +1,208 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Exclusion is a list of hosts and patterns that destinations are excluded
+// by. Each entry is one of:
+//
+//	host      excludes destinations with this host (or [user@]host[:port])
+//	glob      excludes destinations matching the glob (see path.Match),
+//	          with braces expanded (e.g. 'web{1,2}' is 'web1' and 'web2')
+//	/regex/   excludes destinations matching the regular expression
+//	@file     reads more entries from the given file, one per line
+//
+// The entries are matched against the host and the full destination.
+type Exclusion struct {
+	globs   []string
+	regexes []*regexp.Regexp
+}
+
+// ParseExclusion returns an exclusion for the given entries. Each entry may
+// contain multiple comma separated entries (see splitEntries).
+func ParseExclusion(entries []string) (*Exclusion, error) {
+	x := &Exclusion{}
+
+	for _, entry := range entries {
+		for _, item := range splitEntries(entry) {
+			if err := x.Add(item); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return x, nil
+}
+
+// splitEntries splits the given comma separated entries. Commas in regexes
+// (e.g. '/^db\d{1,2}$/') and braces are not considered separators. A regex
+// ends with a '/' followed by a comma, or the end of the entries.
+func splitEntries(entries string) []string {
+	var items []string
+	start, depth, regex := 0, 0, false
+
+	for ix := 0; ix < len(entries); ix++ {
+		c := entries[ix]
+
+		switch {
+		case c == '/' && !regex && strings.TrimSpace(entries[start:ix]) == "":
+			regex = true
+		case regex:
+			if c == '/' && (ix+1 == len(entries) || entries[ix+1] == ',') {
+				regex = false
+			}
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, entries[start:ix])
+			start = ix + 1
+		}
+	}
+
+	return append(items, entries[start:])
+}
+
+// Add adds a single entry to the exclusion. Empty entries are ignored.
+func (x *Exclusion) Add(entry string) error {
+	entry = strings.TrimSpace(entry)
+
+	switch {
+	case entry == "":
+		return nil
+	case strings.HasPrefix(entry, "@"):
+		return x.addFile(entry[1:])
+	case len(entry) > 1 && entry[0] == '/' && entry[len(entry)-1] == '/':
+		re, err := regexp.Compile(entry[1 : len(entry)-1])
+		if err != nil {
+			return fmt.Errorf("invalid regex %q: %w", entry, err)
+		}
+		x.regexes = append(x.regexes, re)
+	default:
+		globs, err := expandBraces(entry)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", entry, err)
+		}
+
+		for _, glob := range globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", entry, err)
+			}
+		}
+		x.globs = append(x.globs, globs...)
+	}
+
+	return nil
+}
+
+// expandBraces returns the globs described by the given pattern, with the
+// alternatives in braces expanded, since path.Match does not support them.
+// For example, 'db{1,2}-{a,b}' results in 'db1-a', 'db1-b', 'db2-a' and
+// 'db2-b'. Braces may be nested.
+func expandBraces(pattern string) ([]string, error) {
+	open := strings.IndexByte(pattern, '{')
+	if open == -1 {
+		if strings.IndexByte(pattern, '}') != -1 {
+			return nil, fmt.Errorf("unmatched '}'")
+		}
+		return []string{pattern}, nil
+	}
+
+	if strings.IndexByte(pattern[:open], '}') != -1 {
+		return nil, fmt.Errorf("unmatched '}'")
+	}
+
+	var alternatives []string
+	start, depth := open+1, 0
+
+	for ix := open + 1; ix < len(pattern); ix++ {
+		switch pattern[ix] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 0 {
+				alternatives = append(alternatives, pattern[start:ix])
+				start = ix + 1
+			}
+		case '}':
+			if depth > 0 {
+				depth--
+				continue
+			}
+
+			alternatives = append(alternatives, pattern[start:ix])
+			prefix, suffix := pattern[:open], pattern[ix+1:]
+
+			var globs []string
+			for _, alternative := range alternatives {
+				expanded, err := expandBraces(prefix + alternative + suffix)
+				if err != nil {
+					return nil, err
+				}
+				globs = append(globs, expanded...)
+			}
+
+			return globs, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unmatched '{'")
+}
+
+// addFile adds the entries found in the given file. Empty lines and lines
+// starting with '#' are ignored.
+func (x *Exclusion) addFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := x.Add(line); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Len returns the number of entries in the exclusion, with each glob
+// expanded from braces counted separately.
+func (x *Exclusion) Len() int {
+	return len(x.globs) + len(x.regexes)
+}
+
+// Match returns true if the destination is excluded.
+func (x *Exclusion) Match(dst Destination) bool {
+	names := []string{dst.Host, dst.String()}
+
+	for _, name := range names {
+		for _, glob := range x.globs {
+			if ok, _ := path.Match(glob, name); ok {
+				return true
+			}
+		}
+
+		for _, re := range x.regexes {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,80 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExclusion(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "maintenance")
+	assert.NoError(t, os.WriteFile(file, []byte("# Comment\ndb2\n\n"), 0o600))
+
+	x, err := ParseExclusion([]string{"web3", "cache*,/^db1$/", "@" + file})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, x.Len())
+
+	assertExcluded := func(server string, expected bool) {
+		assert.Equal(t, expected, x.Match(*ParseDestination(server)), server)
+	}
+
+	assertExcluded("web3", true)
+	assertExcluded("root@web3:22", true)
+	assertExcluded("web3.example.org", false)
+	assertExcluded("cache1", true)
+	assertExcluded("db1", true)
+	assertExcluded("db10", false)
+	assertExcluded("db2", true)
+	assertExcluded("web1", false)
+
+	assert.NoError(t, x.Add("web1"))
+	assertExcluded("web1", true)
+}
+
+func TestExclusionCommas(t *testing.T) {
+	assert.Equal(t,
+		[]string{"web1", " /^db\\d{1,2}$/", "/a,b/", "cache{1,2}", ""},
+		splitEntries("web1, /^db\\d{1,2}$/,/a,b/,cache{1,2},"))
+
+	x, err := ParseExclusion([]string{`/^db\d{1,2}$/,web1`})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, x.Len())
+
+	assert.True(t, x.Match(*ParseDestination("db12")))
+	assert.False(t, x.Match(*ParseDestination("db123")))
+	assert.True(t, x.Match(*ParseDestination("web1")))
+}
+
+func TestExclusionBraces(t *testing.T) {
+	globs, err := expandBraces("db{1,2}-{a,b{c,d}}")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"db1-a", "db1-bc", "db1-bd", "db2-a", "db2-bc", "db2-bd"}, globs)
+
+	x, err := ParseExclusion([]string{"web{1,2},cache{,-*}"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, x.Len())
+
+	for server, expected := range map[string]bool{
+		"web1":       true,
+		"web2":       true,
+		"web3":       false,
+		"web{1,2}":   false,
+		"cache":      true,
+		"cache-ams1": true,
+		"cache1":     false,
+	} {
+		assert.Equal(t, expected, x.Match(*ParseDestination(server)), server)
+	}
+}
+
+func TestExclusionErrors(t *testing.T) {
+	for _, entry := range []string{
+		"[", "/(/", "@/does/not/exist", "web{1,2", "web1}", "web}{1",
+	} {
+		_, err := ParseExclusion([]string{entry})
+		assert.Error(t, err, entry)
+	}
+}
@@ -0,0 +1,62 @@
+package ssh
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sample describes the size of a random subset of destinations, either as
+// an absolute count, or as a percentage of all destinations.
+type Sample struct {
+	Count   int
+	Percent float64
+}
+
+// ParseSample parses a sample size given as count ("5") or as percentage
+// ("10%").
+func ParseSample(text string) (*Sample, error) {
+	text = strings.TrimSpace(text)
+
+	if percent, ok := strings.CutSuffix(text, "%"); ok {
+		value, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || value <= 0 || value > 100 {
+			return nil, fmt.Errorf("invalid percentage: %q", text)
+		}
+
+		return &Sample{Percent: value}, nil
+	}
+
+	value, err := strconv.Atoi(text)
+	if err != nil || value <= 0 {
+		return nil, fmt.Errorf("invalid count: %q", text)
+	}
+
+	return &Sample{Count: value}, nil
+}
+
+// Size returns the number of destinations to pick out of the given total.
+// Percentages are rounded up, so that at least one destination is picked.
+func (s *Sample) Size(total int) int {
+	size := s.Count
+	if s.Percent > 0 {
+		size = int(math.Ceil(float64(total) * s.Percent / 100))
+	}
+
+	if size > total {
+		return total
+	}
+
+	return size
+}
+
+// Pick returns the indexes of a random sample out of the given total, in
+// ascending order.
+func (s *Sample) Pick(rnd *rand.Rand, total int) []int {
+	picked := rnd.Perm(total)[:s.Size(total)]
+	sort.Ints(picked)
+	return picked
+}
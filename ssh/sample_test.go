@@ -0,0 +1,44 @@
+package ssh
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSample(t *testing.T) {
+	sample, err := ParseSample("5")
+	assert.NoError(t, err)
+	assert.Equal(t, &Sample{Count: 5}, sample)
+
+	sample, err = ParseSample("12.5%")
+	assert.NoError(t, err)
+	assert.Equal(t, &Sample{Percent: 12.5}, sample)
+
+	for _, text := range []string{"", "0", "-1", "0%", "101%", "x", "x%"} {
+		_, err := ParseSample(text)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestSampleSize(t *testing.T) {
+	assert.Equal(t, 5, (&Sample{Count: 5}).Size(10))
+	assert.Equal(t, 3, (&Sample{Count: 5}).Size(3))
+	assert.Equal(t, 1, (&Sample{Percent: 10}).Size(3))
+	assert.Equal(t, 5, (&Sample{Percent: 50}).Size(10))
+	assert.Equal(t, 0, (&Sample{Percent: 50}).Size(0))
+}
+
+func TestSamplePick(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	picked := (&Sample{Count: 3}).Pick(rnd, 10)
+
+	assert.Len(t, picked, 3)
+	assert.True(t, sort.IntsAreSorted(picked))
+
+	for _, ix := range picked {
+		assert.True(t, 0 <= ix && ix < 10)
+	}
+}
@@ -0,0 +1,291 @@
+package ssh
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// Selector matches destinations by their labels, using expressions like
+// "role=web && dc!=fra". The following syntax is supported:
+//
+//	key          the label is set
+//	key=value    the label is set to the value (also key==value)
+//	key!=value   the label is not set to the value (or not set at all)
+//	!expr        negates the expression
+//	a && b       both expressions match
+//	a || b       either expression matches
+//	(expr)       groups expressions
+//
+// Values may contain globs (see path.Match) and may be quoted using single
+// or double quotes. The && operator binds stronger than ||.
+type Selector struct {
+	expr selectorExpr
+	text string
+}
+
+// ParseSelector parses the given selector expression.
+func ParseSelector(text string) (*Selector, error) {
+	tokens, err := tokenizeSelector(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+
+	p := selectorParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+
+	return &Selector{expr: expr, text: text}, nil
+}
+
+// Match returns true if the destination's labels match the selector.
+func (s *Selector) Match(dst Destination) bool {
+	return s.expr.match(dst.Labels)
+}
+
+// String returns the selector expression as given.
+func (s *Selector) String() string {
+	return s.text
+}
+
+// selectorExpr is a node in the parsed selector expression.
+type selectorExpr interface {
+	match(labels map[string]string) bool
+}
+
+type andExpr struct{ left, right selectorExpr }
+type orExpr struct{ left, right selectorExpr }
+type notExpr struct{ expr selectorExpr }
+type hasExpr struct{ key string }
+type equalExpr struct{ key, value string }
+
+func (e andExpr) match(l map[string]string) bool {
+	return e.left.match(l) && e.right.match(l)
+}
+
+func (e orExpr) match(l map[string]string) bool {
+	return e.left.match(l) || e.right.match(l)
+}
+
+func (e notExpr) match(l map[string]string) bool {
+	return !e.expr.match(l)
+}
+
+func (e hasExpr) match(l map[string]string) bool {
+	_, ok := l[e.key]
+	return ok
+}
+
+func (e equalExpr) match(l map[string]string) bool {
+	value, ok := l[e.key]
+	if !ok {
+		return false
+	}
+
+	matched, _ := path.Match(e.value, value)
+	return matched
+}
+
+// tokenKind describes the kind of a selector token.
+type tokenKind uint8
+
+const (
+	wordToken tokenKind = iota + 1
+	andToken
+	orToken
+	notToken
+	equalToken
+	notEqualToken
+	openToken
+	closeToken
+)
+
+type selectorToken struct {
+	kind tokenKind
+	text string
+}
+
+// tokenizeSelector splits the selector expression into tokens.
+func tokenizeSelector(text string) ([]selectorToken, error) {
+	tokens := make([]selectorToken, 0)
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); {
+		char := runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case unicode.IsSpace(char):
+			i++
+		case char == '&' && next == '&':
+			tokens = append(tokens, selectorToken{andToken, "&&"})
+			i += 2
+		case char == '|' && next == '|':
+			tokens = append(tokens, selectorToken{orToken, "||"})
+			i += 2
+		case char == '!' && next == '=':
+			tokens = append(tokens, selectorToken{notEqualToken, "!="})
+			i += 2
+		case char == '=' && next == '=':
+			tokens = append(tokens, selectorToken{equalToken, "=="})
+			i += 2
+		case char == '=':
+			tokens = append(tokens, selectorToken{equalToken, "="})
+			i++
+		case char == '!':
+			tokens = append(tokens, selectorToken{notToken, "!"})
+			i++
+		case char == '(':
+			tokens = append(tokens, selectorToken{openToken, "("})
+			i++
+		case char == ')':
+			tokens = append(tokens, selectorToken{closeToken, ")"})
+			i++
+		case char == '"' || char == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != char {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated quote in %q", text)
+			}
+			tokens = append(tokens, selectorToken{
+				wordToken, string(runes[i+1 : end])})
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !strings.ContainsRune(
+				"&|!=()\"' \t\n", runes[end]) {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("unexpected %q in %q", char, text)
+			}
+			tokens = append(tokens, selectorToken{
+				wordToken, string(runes[i:end])})
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+// selectorParser is a recursive descent parser for selector tokens.
+type selectorParser struct {
+	tokens []selectorToken
+	pos    int
+}
+
+// peek returns the kind of the current token, or 0 at the end.
+func (p *selectorParser) peek() tokenKind {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].kind
+	}
+	return 0
+}
+
+func (p *selectorParser) parseOr() (selectorExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == orToken {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *selectorParser) parseAnd() (selectorExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == andToken {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *selectorParser) parseUnary() (selectorExpr, error) {
+	switch p.peek() {
+	case notToken:
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	case openToken:
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != closeToken {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return expr, nil
+	case wordToken:
+		return p.parseCondition()
+	case 0:
+		return nil, fmt.Errorf("unexpected end of selector")
+	default:
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+}
+
+func (p *selectorParser) parseCondition() (selectorExpr, error) {
+	key := p.tokens[p.pos].text
+	p.pos++
+
+	op := p.peek()
+	if op != equalToken && op != notEqualToken {
+		return hasExpr{key}, nil
+	}
+	p.pos++
+
+	if p.peek() != wordToken {
+		return nil, fmt.Errorf("missing value for %q", key)
+	}
+	value := p.tokens[p.pos].text
+	p.pos++
+
+	if _, err := path.Match(value, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", value, err)
+	}
+
+	if op == notEqualToken {
+		return notExpr{equalExpr{key, value}}, nil
+	}
+
+	return equalExpr{key, value}, nil
+}
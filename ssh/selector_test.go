@@ -0,0 +1,54 @@
+package ssh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelector(t *testing.T) {
+	web := Destination{Host: "web1", Labels: map[string]string{
+		"role": "web", "dc": "ams", "canary": ""}}
+	db := Destination{Host: "db1", Labels: map[string]string{
+		"role": "db", "dc": "fra"}}
+	none := Destination{Host: "other"}
+
+	assertMatch := func(expression string, expected ...bool) {
+		selector, err := ParseSelector(expression)
+		assert.NoError(t, err, expression)
+
+		for ix, dst := range []Destination{web, db, none} {
+			assert.Equal(t, expected[ix], selector.Match(dst),
+				"%s on %s", expression, dst.Host)
+		}
+	}
+
+	assertMatch("role=web", true, false, false)
+	assertMatch("role==web", true, false, false)
+	assertMatch("role!=web", false, true, true)
+	assertMatch("canary", true, false, false)
+	assertMatch("!canary", false, true, true)
+	assertMatch("role=web && dc!=fra", true, false, false)
+	assertMatch("role=web || dc=fra", true, true, false)
+	assertMatch("dc=fra || role=web && dc=zrh", false, true, false)
+	assertMatch("(dc=fra || role=web) && dc=ams", true, false, false)
+	assertMatch("role='w*'", true, false, false)
+	assertMatch(`dc="a?s" || role=d*`, true, true, false)
+	assertMatch("!(role=web)", false, true, true)
+}
+
+func TestSelectorErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"role=",
+		"role=web &&",
+		"(role=web",
+		"role=web)",
+		"role='web",
+		"&& role=web",
+		"role=[",
+	} {
+		_, err := ParseSelector(expression)
+		assert.Error(t, err, expression)
+	}
+}
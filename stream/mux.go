@@ -32,15 +32,30 @@ type Mux struct {
 	// workers running
 	workers uint
 
-	// shut delivers a true once no new cmds should be accepted
+	// shut is closed once no new cmds should be accepted
 	shut chan bool
+
+	// raw is true if output is streamed without assembling lines
+	raw bool
 }
 
 // NewMux starts a new mux with the given amount of workers. Each worker is
 // able to process a single command from start to finish.
 func NewMux(ctx context.Context, workers uint) *Mux {
+	return newMux(ctx, workers, false)
+}
+
+// NewRawMux works like NewMux, but streams the output of the commands as it
+// is written, without assembling it into lines (see StreamRawCommand).
+func NewRawMux(ctx context.Context, workers uint) *Mux {
+	return newMux(ctx, workers, true)
+}
+
+// newMux implements NewMux and NewRawMux.
+func newMux(ctx context.Context, workers uint, raw bool) *Mux {
 	m := Mux{
 		ctx:        ctx,
+		raw:        raw,
 		cmds:       make(chan *exec.Cmd),
 		cmdresults: make(chan CommandResult),
 		mu:         &sync.Mutex{},
@@ -84,9 +99,9 @@ func (m *Mux) TrySubmit(cmd *exec.Cmd) bool {
 }
 
 // Shut stops Mux from accepting more commands. This causes the workers to
-// wind down and ensures that the Results channel eventually closes.
+// wind down and ensures that the Results channel eventually closes. Shut
+// does not block, even if the context has been cancelled already.
 func (m *Mux) Shut() {
-	m.shut <- true
 	close(m.shut)
 }
 
@@ -106,8 +121,13 @@ func (m *Mux) worker() {
 
 	// Send results for commands to the channel available to consumers through
 	// the Results method.
+	stream := StreamCommand
+	if m.raw {
+		stream = StreamRawCommand
+	}
+
 	for cmd := range m.cmds {
-		for result := range StreamCommand(m.ctx, cmd) {
+		for result := range stream(m.ctx, cmd) {
 			sent := ContextSend(
 				m.ctx,
 				m.cmdresults,
@@ -27,12 +27,14 @@ func TestMux(t *testing.T) {
 	}
 
 	assert.Equal(t, []Result{
+		{resultType: StartResult},
 		{resultType: StdoutResult, output: "foo\n"},
 		{resultType: ExitResult, exitCode: 0},
-	}, results[foo])
+	}, untimed(t, results[foo]))
 
 	assert.Equal(t, []Result{
+		{resultType: StartResult},
 		{resultType: StdoutResult, output: "bar\n"},
 		{resultType: ExitResult, exitCode: 0},
-	}, results[bar])
+	}, untimed(t, results[bar]))
 }
@@ -1,5 +1,7 @@
 package stream
 
+import "time"
+
 // ResultType desribes the type of a single result coming through the pipe
 type ResultType uint8
 
@@ -15,6 +17,9 @@ const (
 
 	// ExitResult is set on results that have an exit code
 	ExitResult
+
+	// StartResult is sent before a command is started
+	StartResult
 )
 
 // Result denotes a single data object in the result pipe
@@ -30,6 +35,47 @@ type Result struct {
 
 	// exitCode is set to the exit code if resultType is ExitResult
 	exitCode int
+
+	// time is when the result was created, e.g. when the output was written
+	time time.Time
+}
+
+// NewOutputResult returns a StdoutResult or StderrResult with the given
+// output. This is useful to replay output that was recorded earlier.
+func NewOutputResult(resultType ResultType, output string) Result {
+	if resultType != StdoutResult && resultType != StderrResult {
+		panic("tried to create an output result of another type")
+	}
+
+	return Result{resultType: resultType, output: output, time: time.Now()}
+}
+
+// NewExitResult returns an ExitResult with the given exit code.
+func NewExitResult(exitCode int) Result {
+	return Result{resultType: ExitResult, exitCode: exitCode, time: time.Now()}
+}
+
+// NewErrorResult returns an ErrorResult with the given error.
+func NewErrorResult(err error) Result {
+	return Result{resultType: ErrorResult, err: err, time: time.Now()}
+}
+
+// NewStartResult returns a StartResult.
+func NewStartResult() Result {
+	return Result{resultType: StartResult, time: time.Now()}
+}
+
+// WithTime returns a copy of the result with the given time. This is useful
+// to replay results that were recorded earlier.
+func (i Result) WithTime(t time.Time) Result {
+	i.time = t
+	return i
+}
+
+// Time returns when the result was created. For output, this is when the
+// output was received from the command.
+func (i *Result) Time() time.Time {
+	return i.time
 }
 
 // Type returns the ResultType of the result
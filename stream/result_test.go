@@ -3,6 +3,7 @@ package stream
 import (
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestStdoutStderr(t *testing.T) {
@@ -31,3 +32,32 @@ func TestExitCode(t *testing.T) {
 	i.exitCode = 1
 	assert.Equal(t, 1, i.ExitCode())
 }
+
+func TestNewResult(t *testing.T) {
+	stdout := NewOutputResult(StdoutResult, "foo")
+	assert.Equal(t, "foo", stdout.Stdout())
+
+	stderr := NewOutputResult(StderrResult, "bar")
+	assert.Equal(t, "bar", stderr.Stderr())
+
+	assert.Panics(t, func() { NewOutputResult(ExitResult, "") })
+
+	exit := NewExitResult(2)
+	assert.Equal(t, 2, exit.ExitCode())
+
+	err := NewErrorResult(assert.AnError)
+	assert.Equal(t, assert.AnError, err.Err())
+
+	start := NewStartResult()
+	assert.Equal(t, ResultType(StartResult), start.Type())
+}
+
+func TestResultTime(t *testing.T) {
+	before := time.Now()
+	result := NewStartResult()
+	assert.WithinRange(t, result.Time(), before, time.Now())
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	result = result.WithTime(at)
+	assert.Equal(t, at, result.Time())
+}
@@ -2,14 +2,18 @@
 // stderr, error, and exit code through a channel that delivers these partial
 // results as soon as they become available.
 //
-// Note that no assumptions about the output buffering are made. You get the
-// output of each stdout/stderr write call as an Result in the output channel.
-// Usually this means you get output line-by-line (as most stdout/stderr output
-// is written that way, especially if an interactive terminal is involved), but
-// if we receive parts of a line, we will send that part as a single Result.
+// Output is assembled into lines, so each Result holds a single line of
+// stdout or stderr output, including its newline. Lines longer than
+// MaxLineLength are split into multiple Results, and a last line without a
+// newline is sent once the command exits.
+//
+// In raw mode (see StreamRawCommand), no assumptions about the output are
+// made. You get the output of each stdout/stderr write call as a Result, which
+// may contain parts of a line, or multiple lines.
 package stream
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
@@ -24,77 +28,138 @@ const (
 	fromStderr
 )
 
+// MaxLineLength is the maximum number of bytes sent as a single Result.
+// Longer lines are split.
+const MaxLineLength = 64 * 1024
+
 // stream is used to handle output from an os/exec.Cmd and to push it into
 // a channel. Each stream is attached to stdout or stderr (not both).
 type stream struct {
 	source source
 	ctx    context.Context
 	ch     chan Result
+
+	// raw is true if writes are sent as they are, without assembling lines
+	raw bool
+
+	// buffer holds the start of a line, until its newline is written
+	buffer []byte
 }
 
 // Write implements the io.Write interface so we can attach the stream to
 // an os/exec.Cmd.Stdout/Stderr field.
-func (s *stream) Write(bytes []byte) (int, error) {
-	i := Result{output: string(bytes)}
-	if s.source == fromStdout {
-		i.resultType = StdoutResult
-	} else {
-		i.resultType = StderrResult
+func (s *stream) Write(data []byte) (int, error) {
+	if s.raw {
+		if !s.send(data) {
+			return 0, io.ErrClosedPipe
+		}
+		return len(data), nil
+	}
+
+	s.buffer = append(s.buffer, data...)
+
+	for {
+		var line []byte
+
+		ix := bytes.IndexByte(s.buffer, '\n')
+		switch {
+		case ix >= 0 && ix < MaxLineLength:
+			line = s.buffer[:ix+1]
+		case len(s.buffer) >= MaxLineLength:
+			line = s.buffer[:MaxLineLength]
+		default:
+			return len(data), nil
+		}
+
+		if !s.send(line) {
+			return 0, io.ErrClosedPipe
+		}
+		s.buffer = s.buffer[len(line):]
+	}
+}
+
+// flush sends the rest of the output, which does not end in a newline.
+func (s *stream) flush() bool {
+	if len(s.buffer) == 0 {
+		return true
 	}
 
-	if ContextSend(s.ctx, s.ch, i) {
-		return len(bytes), nil
-	} else {
-		return 0, io.ErrClosedPipe
+	line := s.buffer
+	s.buffer = nil
+
+	return s.send(line)
+}
+
+// send sends the given output as a Result.
+func (s *stream) send(output []byte) bool {
+	if s.source == fromStdout {
+		return ContextSend(s.ctx, s.ch,
+			NewOutputResult(StdoutResult, string(output)))
 	}
+
+	return ContextSend(s.ctx, s.ch,
+		NewOutputResult(StderrResult, string(output)))
 }
 
 // StreamCommand takes a exec.Cmd, starts it, and streams the result through
-// the returned channel. Once the command is over, the channel will be
-// closed.
+// the returned channel. The first result is always a StartResult, sent
+// before the command is started. Once the command is over, the channel will
+// be closed.
 func StreamCommand(ctx context.Context, cmd *exec.Cmd) <-chan Result {
-	ch := make(chan Result)
+	return streamCommand(ctx, cmd, false)
+}
 
-	cmd.Stdout = &stream{source: fromStdout, ctx: ctx, ch: ch}
-	cmd.Stderr = &stream{source: fromStderr, ctx: ctx, ch: ch}
+// StreamRawCommand works like StreamCommand, but sends the output as it is
+// written by the command, without assembling it into lines. This is useful
+// for binary output, or progress bars that do not end their lines.
+func StreamRawCommand(ctx context.Context, cmd *exec.Cmd) <-chan Result {
+	return streamCommand(ctx, cmd, true)
+}
 
-	// If the command doesn't start in the first place, return immediately
-	err := cmd.Start()
-	if err != nil {
-		go func() {
-			defer close(ch)
+// streamCommand implements StreamCommand and StreamRawCommand.
+func streamCommand(
+	ctx context.Context,
+	cmd *exec.Cmd,
+	raw bool,
+) <-chan Result {
+	ch := make(chan Result)
 
-			ContextSend(ctx, ch, Result{
-				err:        err,
-				resultType: ErrorResult,
-			})
-		}()
+	stdout := &stream{source: fromStdout, ctx: ctx, ch: ch, raw: raw}
+	stderr := &stream{source: fromStderr, ctx: ctx, ch: ch, raw: raw}
 
-		return ch
-	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
-	// Otherwise await the commands end
 	go func() {
 		defer close(ch)
 
-		err := cmd.Wait()
+		if !ContextSend(ctx, ch, NewStartResult()) {
+			return
+		}
+
+		// If the command doesn't start in the first place, return
+		// immediately
+		err := cmd.Start()
+		if err != nil {
+			ContextSend(ctx, ch, NewErrorResult(err))
+			return
+		}
+
+		// Otherwise await the commands end, and send the remaining output,
+		// as all writes are done once Wait returns
+		err = cmd.Wait()
+		if !stdout.flush() || !stderr.flush() {
+			return
+		}
+
 		var exitErr *exec.ExitError
 		switch {
 		case err == nil:
-			ContextSend(ctx, ch, Result{
-				exitCode:   0,
-				resultType: ExitResult,
-			})
+			ContextSend(ctx, ch, NewExitResult(0))
 		case errors.As(err, &exitErr):
-			ContextSend(ctx, ch, Result{
-				exitCode:   exitErr.ExitCode(),
-				resultType: ExitResult,
-			})
+			ContextSend(ctx, ch, NewExitResult(exitErr.ExitCode()))
 		default:
-			ContextSend(ctx, ch, Result{
-				err:        err,
-				resultType: ErrorResult,
-			})
+			ContextSend(ctx, ch, NewErrorResult(err))
 		}
 	}()
 
@@ -6,21 +6,35 @@ import (
 	"github.com/stretchr/testify/assert"
 	"io/fs"
 	"os/exec"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
 )
 
+// untimed checks that the results have a time, and removes it, so the
+// results can be compared.
+func untimed(t *testing.T, results []Result) []Result {
+	for ix := range results {
+		assert.False(t, results[ix].Time().IsZero())
+		results[ix] = results[ix].WithTime(time.Time{})
+	}
+	return results
+}
+
 func TestStreamCommand(t *testing.T) {
 	run := func(cmd *exec.Cmd) []Result {
 		results := []Result{}
 		for r := range StreamCommand(context.Background(), cmd) {
 			results = append(results, r)
 		}
-		return results
+		return untimed(t, results)
 	}
 
 	assert.Equal(t, []Result{
+		{
+			resultType: StartResult,
+		},
 		{
 			resultType: StdoutResult,
 			output:     "foo\n",
@@ -32,6 +46,9 @@ func TestStreamCommand(t *testing.T) {
 	}, run(exec.Command("echo", "foo")))
 
 	assert.Equal(t, []Result{
+		{
+			resultType: StartResult,
+		},
 		{
 			resultType: StderrResult,
 			output:     "bar\n",
@@ -43,6 +60,9 @@ func TestStreamCommand(t *testing.T) {
 	}, run(exec.Command("sh", "-c", "echo bar >&2")))
 
 	assert.Equal(t, []Result{
+		{
+			resultType: StartResult,
+		},
 		{
 			resultType: ExitResult,
 			exitCode:   0,
@@ -50,6 +70,9 @@ func TestStreamCommand(t *testing.T) {
 	}, run(exec.Command("true")))
 
 	assert.Equal(t, []Result{
+		{
+			resultType: StartResult,
+		},
 		{
 			resultType: ExitResult,
 			exitCode:   1,
@@ -57,6 +80,9 @@ func TestStreamCommand(t *testing.T) {
 	}, run(exec.Command("false")))
 
 	assert.Equal(t, []Result{
+		{
+			resultType: StartResult,
+		},
 		{
 			resultType: ErrorResult,
 			err: &fs.PathError{
@@ -78,3 +104,58 @@ func TestStreamCommandAbort(t *testing.T) {
 	assert.WithinDuration(t, start, time.Now(), 1*time.Second)
 	fmt.Print(result)
 }
+
+func TestStreamLines(t *testing.T) {
+	write := func(raw bool, writes ...string) []string {
+		ch := make(chan Result, 16)
+		s := &stream{
+			source: fromStdout,
+			ctx:    context.Background(),
+			ch:     ch,
+			raw:    raw,
+		}
+
+		for _, w := range writes {
+			n, err := s.Write([]byte(w))
+			assert.NoError(t, err)
+			assert.Equal(t, len(w), n)
+		}
+		assert.True(t, s.flush())
+		close(ch)
+
+		lines := []string{}
+		for r := range ch {
+			lines = append(lines, r.Stdout())
+		}
+		return lines
+	}
+
+	assert.Equal(t,
+		[]string{"foo\n", "bar\n", "baz"},
+		write(false, "fo", "o\nb", "ar\nbaz"))
+
+	assert.Equal(t,
+		[]string{"fo", "o\nb", "ar\nbaz"},
+		write(true, "fo", "o\nb", "ar\nbaz"))
+
+	long := strings.Repeat("x", MaxLineLength)
+	assert.Equal(t,
+		[]string{long, "yy\n"},
+		write(false, long[:10], long[10:]+"yy\n"))
+}
+
+func TestStreamCommandLines(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "printf a; sleep 0.1; printf 'b\\nc'")
+
+	results := []Result{}
+	for r := range StreamCommand(context.Background(), cmd) {
+		results = append(results, r)
+	}
+
+	assert.Equal(t, []Result{
+		{resultType: StartResult},
+		{resultType: StdoutResult, output: "ab\n"},
+		{resultType: StdoutResult, output: "c"},
+		{resultType: ExitResult, exitCode: 0},
+	}, untimed(t, results))
+}
@@ -0,0 +1,136 @@
+package term
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// sample is the number a server reported in the aggregate mode.
+type sample struct {
+	server string
+	value  float64
+}
+
+// parseNumber returns the number on the last line of the given output that
+// is not empty, so that headers (e.g. "Use%" in df) are ignored. The number
+// may be followed by a '%' sign.
+func parseNumber(output string) (float64, bool) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	line := strings.TrimSpace(lines[len(lines)-1])
+	line = strings.TrimSpace(strings.TrimSuffix(line, "%"))
+
+	value, err := strconv.ParseFloat(line, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+
+	return value, true
+}
+
+// percentile returns the given percentile (0-100) of the sorted values,
+// interpolating linearly between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// outliers returns the samples outside of the Tukey fences, i.e. more than
+// 1.5 times the interquartile range below the first, or above the third
+// quartile. The samples must be sorted by value.
+func outliers(samples []sample, sorted []float64) []sample {
+	q1, q3 := percentile(sorted, 25), percentile(sorted, 75)
+	low, high := q1-1.5*(q3-q1), q3+1.5*(q3-q1)
+
+	var found []sample
+	for _, s := range samples {
+		if s.value < low || high < s.value {
+			found = append(found, s)
+		}
+	}
+
+	return found
+}
+
+// formatNumber returns the given number rounded to three decimals, without
+// trailing zeroes.
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(math.Round(value*1000)/1000, 'f', -1, 64)
+}
+
+// printAggregate prints statistics of the numbers reported by the servers,
+// and the servers whose number is an outlier. Servers that were skipped
+// are left out, as are servers that did not succeed, or did not report a
+// number, which are listed on stderr. Must be called with the lock held.
+func (r *Report) printAggregate() {
+	samples := make([]sample, 0, len(r.order))
+
+	for _, server := range r.order {
+		outcome := r.servers[server]
+		if outcome.Skipped {
+			continue
+		}
+
+		if !outcome.Success() {
+			r.leaveOut(outcome, summaryResult(outcome))
+			continue
+		}
+
+		value, ok := parseNumber(outcome.Stdout())
+		if !ok {
+			r.leaveOut(outcome, "no number")
+			continue
+		}
+
+		samples = append(samples, sample{server: server, value: value})
+	}
+
+	if len(samples) == 0 {
+		return
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].value < samples[j].value
+	})
+
+	sorted := make([]float64, len(samples))
+	sum := 0.0
+	for ix, s := range samples {
+		sorted[ix] = s.value
+		sum += s.value
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+
+	w := tabwriter.NewWriter(r.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "count\t%d\n", len(samples))
+	fmt.Fprintf(w, "min\t%s\t(%s)\n", formatNumber(first.value), first.server)
+	fmt.Fprintf(w, "max\t%s\t(%s)\n", formatNumber(last.value), last.server)
+	fmt.Fprintf(w, "mean\t%s\n", formatNumber(sum/float64(len(samples))))
+	for _, p := range []float64{50, 95, 99} {
+		fmt.Fprintf(w, "p%g\t%s\n", p, formatNumber(percentile(sorted, p)))
+	}
+	w.Flush()
+
+	found := outliers(samples, sorted)
+	if len(found) == 0 {
+		return
+	}
+
+	names := make([]string, len(found))
+	for ix, s := range found {
+		names[ix] = fmt.Sprintf("%s (%s)", s.server, formatNumber(s.value))
+	}
+
+	fmt.Fprint(r.Stdout, "outliers: ", strings.Join(names, ", "), "\n")
+}
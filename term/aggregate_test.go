@@ -0,0 +1,61 @@
+package term
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNumber(t *testing.T) {
+	for output, expected := range map[string]float64{
+		"42\n":         42,
+		" 0.08 \n":     0.08,
+		"Use%\n 23%\n": 23,
+		"-1.5e2":       -150,
+		"12\n\n":       12,
+	} {
+		value, ok := parseNumber(output)
+		assert.True(t, ok, output)
+		assert.Equal(t, expected, value, output)
+	}
+
+	for _, output := range []string{
+		"none\n", "", "0.08 0.03 0.01\n", "2024-01-05\n", "error 42\n",
+		"NaN\n", "inf\n",
+	} {
+		_, ok := parseNumber(output)
+		assert.False(t, ok, output)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4}
+	assert.Equal(t, 1.0, percentile(sorted, 0))
+	assert.Equal(t, 2.5, percentile(sorted, 50))
+	assert.Equal(t, 4.0, percentile(sorted, 100))
+	assert.Equal(t, 7.0, percentile([]float64{7}, 95))
+}
+
+func TestAggregateReport(t *testing.T) {
+	rep, stdout, stderr := testReport(AggregateReport)
+
+	for ix, value := range []string{"10", "12", "11", "13", "95%", "none"} {
+		run(rep, fmt.Sprintf("web%d", ix+1), "echo "+value)
+	}
+	run(rep, "web7", "echo 1; exit 1")
+	rep.Finish()
+
+	assert.Equal(t, ""+
+		"count  5\n"+
+		"min    10  (web1)\n"+
+		"max    95  (web5)\n"+
+		"mean   28.2\n"+
+		"p50    12\n"+
+		"p95    78.6\n"+
+		"p99    91.72\n"+
+		"outliers: web5 (95)\n", stdout.String())
+	assert.Equal(t, ""+
+		"web6: left out (no number)\n"+
+		"web7: left out (exit 1)\n", stderr.String())
+}
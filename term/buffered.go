@@ -0,0 +1,103 @@
+package term
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// printBlock prints the output of the server as a single block, with a
+// header showing its result, unless it was printed before. Must be called
+// with the lock held.
+func (r *Report) printBlock(outcome *Outcome) {
+	if outcome.printed {
+		return
+	}
+	outcome.printed = true
+
+	header := fmt.Sprintf("=== %s (%s) ===",
+		outcome.Server, blockResult(outcome))
+	fmt.Fprint(r.Stdout, r.paint(serverColor(outcome.Server), header), "\n")
+
+	step := ""
+	lineStart := true
+
+	for _, output := range outcome.Output {
+		if output.Step != step {
+			if !lineStart {
+				fmt.Fprint(r.Stdout, "\n")
+				lineStart = true
+			}
+
+			step = output.Step
+			fmt.Fprint(r.Stdout, "--- ", step, " ---\n")
+		}
+
+		file := r.Stdout
+		if output.Stderr {
+			file = r.Stderr
+		}
+
+		for _, part := range strings.SplitAfter(output.Text, "\n") {
+			if part == "" || !r.shows(part) {
+				continue
+			}
+
+			prefix := ""
+			if lineStart {
+				prefix = r.timestamp(output.Time)
+			}
+
+			text, newline := strings.CutSuffix(part, "\n")
+			if output.Stderr {
+				text = r.paintStderr(red, text)
+			}
+
+			fmt.Fprint(file, prefix, text)
+			if newline {
+				fmt.Fprint(file, "\n")
+			}
+			lineStart = newline
+		}
+	}
+
+	// The block always ends with a newline
+	if !lineStart {
+		fmt.Fprint(r.Stdout, "\n")
+	}
+
+	if outcome.Truncated {
+		fmt.Fprint(r.Stdout, "--- output truncated ---\n")
+	}
+}
+
+// printDone prints the blocks of the servers that are done, in the order
+// they were queued, up to the first server that is not done yet. Must be
+// called with the lock held.
+func (r *Report) printDone() {
+	for ; r.next < len(r.order); r.next++ {
+		outcome := r.servers[r.order[r.next]]
+		if !outcome.done {
+			return
+		}
+
+		r.printBlock(outcome)
+	}
+}
+
+// blockResult returns the result of the server shown in the header of its
+// block, like "exit 0, 1.2s".
+func blockResult(outcome *Outcome) string {
+	duration := outcome.Duration().Round(100 * time.Millisecond)
+
+	switch {
+	case outcome.Skipped:
+		return "skipped"
+	case outcome.Err != nil:
+		return fmt.Sprintf("error: %s, %s", outcome.Err, duration)
+	case outcome.Exited:
+		return fmt.Sprintf("exit %d, %s", outcome.ExitCode, duration)
+	default:
+		return "not done"
+	}
+}
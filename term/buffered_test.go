@@ -0,0 +1,80 @@
+package term
+
+import (
+	"context"
+	"os/exec"
+	"regexp"
+	"testing"
+
+	"github.com/href/ssh-each/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+// durations matches the durations in block headers
+var durations = regexp.MustCompile(`, [0-9.]+m?s\)`)
+
+func TestBufferedReport(t *testing.T) {
+	rep, stdout, stderr := testReport(BufferedReport)
+
+	run(rep, "web1", "echo foo; echo bar >&2; printf baz")
+	assert.Equal(t, "", stdout.String())
+
+	rep.Done("web1")
+	assert.Equal(t, "=== web1 (exit 0, 0s) ===\nfoo\nbaz\n",
+		durations.ReplaceAllString(stdout.String(), ", 0s)"))
+	assert.Equal(t, "bar\n", stderr.String())
+
+	// Servers not marked as done are shown at the end
+	stdout.Reset()
+	run(rep, "web2", "exit 3")
+	rep.Done("web1")
+	rep.Finish()
+
+	assert.Equal(t, "=== web2 (exit 3, 0s) ===\n",
+		durations.ReplaceAllString(stdout.String(), ", 0s)"))
+}
+
+func TestBufferedReportSteps(t *testing.T) {
+	rep, stdout, _ := testReport(BufferedReport)
+
+	for _, step := range []string{"one", "two"} {
+		cmd := exec.Command("echo", step)
+		rep.AssociateOrigin(cmd, Origin{Server: "web1", Step: step})
+		for result := range stream.StreamCommand(context.Background(), cmd) {
+			rep.On(stream.CommandResult{Command: cmd, Result: result})
+		}
+	}
+	rep.Done("web1")
+
+	assert.Equal(t, ""+
+		"=== web1 (exit 0, 0s) ===\n"+
+		"--- one ---\n"+
+		"one\n"+
+		"--- two ---\n"+
+		"two\n", durations.ReplaceAllString(stdout.String(), ", 0s)"))
+}
+
+func TestBufferedReportOrdered(t *testing.T) {
+	rep, stdout, _ := testReport(BufferedReport)
+	rep.Ordered = true
+
+	rep.Queue("web1")
+	rep.Queue("web2")
+	rep.Queue("web3")
+
+	run(rep, "web2", "echo two")
+	rep.Done("web2")
+	assert.Equal(t, "", stdout.String())
+
+	run(rep, "web1", "echo one")
+	rep.Done("web1")
+
+	run(rep, "web3", "echo three")
+	rep.Done("web3")
+
+	assert.Equal(t, ""+
+		"=== web1 (exit 0, 0s) ===\none\n"+
+		"=== web2 (exit 0, 0s) ===\ntwo\n"+
+		"=== web3 (exit 0, 0s) ===\nthree\n",
+		durations.ReplaceAllString(stdout.String(), ", 0s)"))
+}
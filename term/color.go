@@ -0,0 +1,94 @@
+package term
+
+import (
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+// ColorMode defines when the output is colorized.
+type ColorMode uint8
+
+const (
+	// AutoColor colorizes the output if it is a terminal, unless the
+	// NO_COLOR environment variable is set, default.
+	AutoColor ColorMode = iota
+
+	// AlwaysColor colorizes the output.
+	AlwaysColor
+
+	// NeverColor does not colorize the output.
+	NeverColor
+)
+
+// ColorModeFromString returns the ColorMode for the given string. Uses the
+// comma ok idiom to indicate if that worked.
+func ColorModeFromString(mode string) (ColorMode, bool) {
+	switch mode {
+	case "", "auto":
+		return AutoColor, true
+	case "always":
+		return AlwaysColor, true
+	case "never":
+		return NeverColor, true
+	default:
+		return 0, false
+	}
+}
+
+// Enabled returns true if output written to the given writer should be
+// colorized.
+func (m ColorMode) Enabled(w io.Writer) bool {
+	switch m {
+	case AlwaysColor:
+		return true
+	case NeverColor:
+		return false
+	default:
+		return os.Getenv("NO_COLOR") == "" && IsTerminal(w)
+	}
+}
+
+// ANSI escape codes used for colors
+const (
+	red    = "31"
+	green  = "32"
+	yellow = "33"
+	reset  = "\x1b[0m"
+)
+
+// serverColors are the colors used for server names. Red is not used, as it
+// denotes failures and stderr output.
+var serverColors = []string{
+	"32", "33", "34", "35", "36", "92", "93", "94", "95", "96",
+}
+
+// serverColor returns the color of the given server, which is always the
+// same for the same server.
+func serverColor(server string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(server))
+
+	return serverColors[hash.Sum32()%uint32(len(serverColors))]
+}
+
+// paint returns the text in the given color, if the output written to
+// Stdout is colorized.
+func (r *Report) paint(color string, text string) string {
+	return colorize(r.Color, color, text)
+}
+
+// paintStderr returns the text in the given color, if the output written
+// to Stderr is colorized.
+func (r *Report) paintStderr(color string, text string) string {
+	return colorize(r.ColorStderr, color, text)
+}
+
+// colorize returns the text in the given color, if enabled.
+func colorize(enabled bool, color string, text string) string {
+	if !enabled || text == "" {
+		return text
+	}
+
+	return "\x1b[" + color + "m" + text + reset
+}
@@ -0,0 +1,70 @@
+package term
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColorModeEnabled(t *testing.T) {
+	buffer := &bytes.Buffer{}
+
+	mode, ok := ColorModeFromString("always")
+	assert.True(t, ok)
+	assert.True(t, mode.Enabled(buffer))
+
+	mode, ok = ColorModeFromString("never")
+	assert.True(t, ok)
+	assert.False(t, mode.Enabled(buffer))
+
+	// Buffers are not terminals
+	mode, ok = ColorModeFromString("auto")
+	assert.True(t, ok)
+	assert.False(t, mode.Enabled(buffer))
+
+	// Character devices are not necessarily terminals
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	defer devNull.Close()
+	assert.False(t, mode.Enabled(devNull))
+
+	_, ok = ColorModeFromString("sometimes")
+	assert.False(t, ok)
+}
+
+func TestServerColor(t *testing.T) {
+	assert.Equal(t, serverColor("web1"), serverColor("web1"))
+	assert.NotEqual(t, serverColor("web1"), serverColor("web2"))
+}
+
+func TestColorReport(t *testing.T) {
+	rep, stdout, stderr := testReport(HostReport)
+	rep.Color = true
+	rep.ColorStderr = true
+
+	run(rep, "web1", "echo foo; echo bar >&2")
+
+	prefix := "\x1b[" + serverColor("web1") + "mweb1: \x1b[0m"
+	assert.Equal(t, prefix+"foo\n", stdout.String())
+	assert.Equal(t, prefix+"\x1b[31mbar\x1b[0m\n", stderr.String())
+
+	// Colors are enabled for each stream separately (e.g. 2>err.log)
+	rep, stdout, stderr = testReport(HostReport)
+	rep.Color = true
+
+	run(rep, "web1", "echo foo; echo bar >&2")
+
+	assert.Equal(t, prefix+"foo\n", stdout.String())
+	assert.Equal(t, "web1: bar\n", stderr.String())
+
+	rep, stdout, _ = testReport(CheckReport)
+	rep.Color = true
+
+	run(rep, "web1", "true")
+	run(rep, "web2", "false")
+
+	assert.Equal(t,
+		"web1: \x1b[32m✓\x1b[0m\nweb2: \x1b[31mx\x1b[0m\n", stdout.String())
+}
@@ -0,0 +1,517 @@
+package term
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	xterm "golang.org/x/term"
+)
+
+// dashboardInterval is the time between updates of the dashboard.
+const dashboardInterval = 200 * time.Millisecond
+
+// dashboardFilters are the states the dashboard cycles through when
+// filtering (0 shows all servers).
+var dashboardFilters = []State{
+	0, Queued, Running, Succeeded, Failed, Errored, Skipped,
+}
+
+// escapeSequence matches the ANSI escape sequences removed from the output.
+var escapeSequence = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]`)
+
+// Dashboard is a full-screen terminal UI, showing the state of each server
+// of a report, with the option to view the output of a single server.
+//
+// The report has to capture the output of the servers (see
+// Report.Capture), and should not write to the terminal itself.
+type Dashboard struct {
+	// Report the servers are shown from.
+	Report *Report
+
+	// Cancel stops the commands of the given server, returns false if that
+	// is not possible (anymore).
+	Cancel func(server string) bool
+
+	// Quit stops all commands, before the dashboard is closed.
+	Quit func()
+
+	// tty is the terminal the dashboard is shown on
+	tty *os.File
+
+	// state of the user interface
+	selected string
+	filter   int
+	viewing  bool
+	scroll   int
+	message  string
+}
+
+// dashboardLine is a line of output shown in the output view.
+type dashboardLine struct {
+	text   string
+	stderr bool
+	step   bool
+}
+
+// Show shows the dashboard on the terminal, until the user quits. The user
+// may only quit once done is closed, otherwise Quit is called first.
+func (d *Dashboard) Show(done <-chan struct{}) error {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	d.tty = tty
+
+	state, err := xterm.MakeRaw(int(tty.Fd()))
+	if err != nil {
+		return err
+	}
+	defer xterm.Restore(int(tty.Fd()), state)
+
+	// Use the alternate screen, without cursor
+	fmt.Fprint(tty, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(tty, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go readKeys(tty, keys)
+
+	ticker := time.NewTicker(dashboardInterval)
+	defer ticker.Stop()
+
+	finished := false
+	for {
+		d.draw(finished)
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			finished = true
+			done = nil
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			if d.handle(key) {
+				if !finished {
+					d.Quit()
+					<-done
+				}
+				return nil
+			}
+		}
+	}
+}
+
+// readKeys reads the keys pressed on the terminal, until it is closed.
+func readKeys(tty io.Reader, keys chan<- string) {
+	defer close(keys)
+
+	buffer := make([]byte, 16)
+	for {
+		n, err := tty.Read(buffer)
+		if err != nil {
+			return
+		}
+
+		for _, key := range parseKeys(buffer[:n]) {
+			keys <- key
+		}
+	}
+}
+
+// parseKeys returns the names of the keys in the given input.
+func parseKeys(input []byte) []string {
+	keys := make([]string, 0, len(input))
+
+	for len(input) > 0 {
+		if len(input) >= 3 && input[0] == '\x1b' &&
+			(input[1] == '[' || input[1] == 'O') {
+
+			switch {
+			case input[2] == 'A':
+				keys = append(keys, "up")
+			case input[2] == 'B':
+				keys = append(keys, "down")
+			case input[2] == 'C':
+				keys = append(keys, "right")
+			case input[2] == 'D':
+				keys = append(keys, "left")
+			case len(input) >= 4 && string(input[2:4]) == "5~":
+				keys = append(keys, "pgup")
+				input = input[1:]
+			case len(input) >= 4 && string(input[2:4]) == "6~":
+				keys = append(keys, "pgdown")
+				input = input[1:]
+			}
+
+			input = input[3:]
+			continue
+		}
+
+		switch input[0] {
+		case '\x1b':
+			keys = append(keys, "esc")
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case '\x03':
+			keys = append(keys, "ctrl-c")
+		case '\x7f':
+			keys = append(keys, "backspace")
+		default:
+			keys = append(keys, string(input[0]))
+		}
+
+		input = input[1:]
+	}
+
+	return keys
+}
+
+// handle changes the dashboard according to the given key. Returns true if
+// the user wants to quit.
+func (d *Dashboard) handle(key string) bool {
+	d.message = ""
+
+	if key == "ctrl-c" || (key == "q" && !d.viewing) {
+		return true
+	}
+
+	if d.viewing {
+		switch key {
+		case "q", "esc", "backspace", "left", "h":
+			d.viewing = false
+		case "up", "k":
+			d.scroll++
+		case "down", "j":
+			d.scroll = max(d.scroll-1, 0)
+		case "pgup":
+			d.scroll += d.height() / 2
+		case "pgdown":
+			d.scroll = max(d.scroll-d.height()/2, 0)
+		case "g":
+			d.scroll = 1 << 30
+		case "G":
+			d.scroll = 0
+		}
+		return false
+	}
+
+	outcomes := d.outcomes()
+	selected := d.index(outcomes)
+
+	switch key {
+	case "up", "k":
+		selected--
+	case "down", "j":
+		selected++
+	case "pgup":
+		selected -= d.height() / 2
+	case "pgdown":
+		selected += d.height() / 2
+	case "f":
+		d.filter = (d.filter + 1) % len(dashboardFilters)
+		return false
+	case "enter", "right", "l":
+		if selected >= 0 && selected < len(outcomes) {
+			d.viewing = true
+			d.scroll = 0
+		}
+	case "c":
+		if selected >= 0 && selected < len(outcomes) {
+			server := outcomes[selected].Server
+			if d.Cancel(server) {
+				d.message = "Cancelled " + server
+			} else {
+				d.message = "Cannot cancel " + server + ", it is done"
+			}
+		}
+	}
+
+	if len(outcomes) > 0 {
+		selected = min(max(selected, 0), len(outcomes)-1)
+		d.selected = outcomes[selected].Server
+	}
+
+	return false
+}
+
+// outcomes returns the outcomes of the servers matching the filter.
+func (d *Dashboard) outcomes() []Outcome {
+	filter := dashboardFilters[d.filter]
+	outcomes := d.Report.Outcomes()
+
+	if filter == 0 {
+		return outcomes
+	}
+
+	matching := make([]Outcome, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		if outcome.State() == filter {
+			matching = append(matching, outcome)
+		}
+	}
+	return matching
+}
+
+// index returns the position of the selected server in the given outcomes,
+// or 0 if it is not found.
+func (d *Dashboard) index(outcomes []Outcome) int {
+	for ix, outcome := range outcomes {
+		if outcome.Server == d.selected {
+			return ix
+		}
+	}
+	return 0
+}
+
+// size returns the width and height of the terminal.
+func (d *Dashboard) size() (int, int) {
+	width, height, err := xterm.GetSize(int(d.tty.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// height returns the number of lines available for servers or output.
+func (d *Dashboard) height() int {
+	_, height := d.size()
+	return max(height-3, 1)
+}
+
+// draw renders the current view on the terminal.
+func (d *Dashboard) draw(finished bool) {
+	width, _ := d.size()
+	screen := &strings.Builder{}
+
+	screen.WriteString("\x1b[H")
+
+	var lines []string
+	var help string
+
+	outcomes := d.outcomes()
+	if d.viewing && len(outcomes) > 0 {
+		lines = d.output(outcomes[d.index(outcomes)], width)
+		help = "[↑/↓/PgUp/PgDn] scroll  [g/G] top/bottom  [q] back"
+	} else {
+		lines = d.list(outcomes, width)
+		help = "[↑/↓] select  [enter] output  [f] filter  [c] cancel  " +
+			"[q] quit"
+	}
+
+	for _, line := range lines {
+		screen.WriteString(line)
+		screen.WriteString("\x1b[K\r\n")
+	}
+
+	status := help
+	switch {
+	case d.message != "":
+		status = d.message
+	case finished:
+		status = "All done. " + help
+	}
+
+	screen.WriteString("\x1b[J\x1b[7m")
+	screen.WriteString(pad(status, width))
+	screen.WriteString("\x1b[0m")
+
+	fmt.Fprint(d.tty, screen.String())
+}
+
+// summary returns the first line of the dashboard, with the number of
+// servers in each state.
+func (d *Dashboard) summary(width int) string {
+	filter := "all"
+	if dashboardFilters[d.filter] != 0 {
+		filter = dashboardFilters[d.filter].String()
+	}
+
+	return "\x1b[1m" + pad(fmt.Sprintf("ssh-each: %s (showing %s)",
+		d.Report.Summary(), filter), width) + "\x1b[0m"
+}
+
+// list returns the lines of the server list.
+func (d *Dashboard) list(outcomes []Outcome, width int) []string {
+	height := d.height()
+	lines := []string{
+		d.summary(width),
+		pad(fmt.Sprintf("  %-8s %9s  %-24s %s",
+			"STATE", "DURATION", "SERVER", "LAST OUTPUT"), width),
+	}
+
+	// Scroll the list, so that the selected server is shown
+	selected := d.index(outcomes)
+	offset := max(selected-height+1, 0)
+
+	for ix := offset; ix < len(outcomes) && ix < offset+height; ix++ {
+		outcome := &outcomes[ix]
+
+		cursor := "  "
+		if ix == selected {
+			cursor = "> "
+		}
+
+		line := pad(fmt.Sprintf("%s%-8s %9s  %-24s %s",
+			cursor,
+			outcome.State(),
+			duration(outcome),
+			outcome.Server,
+			lastLine(outcome),
+		), width)
+
+		if ix == selected {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		} else {
+			line = stateColor(outcome.State(), line)
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// output returns the lines of the output view of the given server.
+func (d *Dashboard) output(outcome Outcome, width int) []string {
+	height := d.height()
+	header := fmt.Sprintf("%s: %s, %s", outcome.Server, outcome.State(),
+		duration(&outcome))
+	if outcome.Truncated {
+		header += " (output truncated)"
+	}
+
+	lines := []string{"\x1b[1m" + pad(header, width) + "\x1b[0m", ""}
+
+	// Scroll from the bottom, so new output is shown as it arrives
+	output := outputLines(outcome)
+	d.scroll = min(d.scroll, max(len(output)-height, 0))
+	end := len(output) - d.scroll
+	start := max(end-height, 0)
+
+	for _, line := range output[start:end] {
+		text := pad(line.text, width)
+		switch {
+		case line.step:
+			text = "\x1b[1m" + text + "\x1b[0m"
+		case line.stderr:
+			text = "\x1b[31m" + text + "\x1b[0m"
+		}
+		lines = append(lines, text)
+	}
+
+	return lines
+}
+
+// outputLines splits the output of the server into lines, with a line
+// before the output of each step.
+func outputLines(outcome Outcome) []dashboardLine {
+	lines := make([]dashboardLine, 0, len(outcome.Output))
+	step := ""
+	open := false
+
+	for _, output := range outcome.Output {
+		if output.Step != step {
+			step = output.Step
+			lines = append(lines, dashboardLine{
+				text: "[" + step + "]",
+				step: true,
+			})
+			open = false
+		}
+
+		for _, part := range strings.SplitAfter(output.Text, "\n") {
+			if part == "" {
+				continue
+			}
+
+			text, newline := strings.CutSuffix(part, "\n")
+			if open {
+				lines[len(lines)-1].text += sanitize(text)
+			} else {
+				lines = append(lines, dashboardLine{
+					text:   sanitize(text),
+					stderr: output.Stderr,
+				})
+			}
+			open = !newline
+		}
+	}
+
+	return lines
+}
+
+// lastLine returns the last non-empty line of output of the server.
+func lastLine(outcome *Outcome) string {
+	for ix := len(outcome.Output) - 1; ix >= 0; ix-- {
+		text := strings.TrimRight(outcome.Output[ix].Text, "\r\n")
+		if text == "" {
+			continue
+		}
+
+		if cut := strings.LastIndexByte(text, '\n'); cut >= 0 {
+			text = text[cut+1:]
+		}
+
+		return sanitize(text)
+	}
+
+	return ""
+}
+
+// duration returns the time the server ran its commands so far.
+func duration(outcome *Outcome) string {
+	switch {
+	case outcome.Start.IsZero():
+		return "-"
+	case outcome.State() == Running:
+		return time.Since(outcome.Start).Round(100 * time.Millisecond).String()
+	default:
+		return outcome.Duration().Round(100 * time.Millisecond).String()
+	}
+}
+
+// stateColor colors the given text according to the state.
+func stateColor(state State, text string) string {
+	switch state {
+	case Succeeded:
+		return "\x1b[" + green + "m" + text + reset
+	case Failed, Errored:
+		return "\x1b[" + red + "m" + text + reset
+	case Skipped:
+		return "\x1b[" + yellow + "m" + text + reset
+	default:
+		return text
+	}
+}
+
+// sanitize removes escape sequences and control characters from the text,
+// so that it does not interfere with the dashboard.
+func sanitize(text string) string {
+	text = escapeSequence.ReplaceAllString(text, "")
+	text = strings.ReplaceAll(text, "\t", "    ")
+
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// pad cuts or pads the text to the given width.
+func pad(text string, width int) string {
+	length := utf8.RuneCountInString(text)
+
+	if length > width {
+		return string([]rune(text)[:width])
+	}
+
+	return text + strings.Repeat(" ", width-length)
+}
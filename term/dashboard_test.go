@@ -0,0 +1,75 @@
+package term
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeys(t *testing.T) {
+	assert.Equal(t,
+		[]string{"up", "down", "pgup", "pgdown", "enter", "q", "esc"},
+		parseKeys([]byte("\x1b[A\x1bOB\x1b[5~\x1b[6~\rq\x1b")))
+}
+
+func TestOutputLines(t *testing.T) {
+	outcome := Outcome{Output: []Output{
+		{Step: "one", Text: "foo\n"},
+		{Step: "one", Text: "b"},
+		{Step: "one", Text: "ar\n"},
+		{Step: "two", Text: "\x1b[31mbaz\x1b[0m\tqux\n", Stderr: true},
+	}}
+
+	assert.Equal(t, []dashboardLine{
+		{text: "[one]", step: true},
+		{text: "foo"},
+		{text: "bar"},
+		{text: "[two]", step: true},
+		{text: "baz    qux", stderr: true},
+	}, outputLines(outcome))
+
+	assert.Equal(t, "baz    qux", lastLine(&outcome))
+	assert.Equal(t, "", lastLine(&Outcome{}))
+}
+
+func TestPad(t *testing.T) {
+	assert.Equal(t, "✓  ", pad("✓", 3))
+	assert.Equal(t, "ab", pad("abc", 2))
+}
+
+func TestDashboardHandle(t *testing.T) {
+	rep, _, _ := testReport(CheckReport)
+	rep.Queue("web1")
+	rep.Queue("web2")
+	run(rep, "web3", "true")
+
+	cancelled := ""
+	d := &Dashboard{Report: rep, Cancel: func(server string) bool {
+		cancelled = server
+		return true
+	}}
+
+	assert.False(t, d.handle("down"))
+	assert.Equal(t, "web2", d.selected)
+
+	assert.False(t, d.handle("c"))
+	assert.Equal(t, "web2", cancelled)
+
+	// The selection stays within the servers shown
+	d.handle("down")
+	d.handle("down")
+	assert.Equal(t, "web3", d.selected)
+
+	// Filter by queued servers, then succeeded servers
+	d.handle("f")
+	assert.Len(t, d.outcomes(), 2)
+	d.handle("f")
+	d.handle("f")
+	assert.Len(t, d.outcomes(), 1)
+
+	assert.False(t, d.handle("enter"))
+	assert.True(t, d.viewing)
+	assert.False(t, d.handle("q"))
+	assert.False(t, d.viewing)
+	assert.True(t, d.handle("q"))
+}
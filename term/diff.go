@@ -0,0 +1,111 @@
+package term
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// printDiff prints a unified diff of each server's stdout output against the
+// output of the reference server, or "identical" if there is no difference.
+// Must be called with the lock held.
+func (r *Report) printDiff() {
+	if len(r.order) == 0 {
+		return
+	}
+
+	reference := r.Reference
+	if reference == "" {
+		reference = r.firstComparable()
+	}
+
+	expected, ok := r.servers[reference]
+	if !ok {
+		fmt.Fprintf(r.Stderr, "Reference server not found: %s\n", reference)
+		return
+	}
+
+	fmt.Fprint(r.Stdout, reference, ": reference\n")
+
+	for _, server := range r.order {
+		if server == reference {
+			continue
+		}
+
+		outcome := r.servers[server]
+		switch {
+		case outcome.Skipped:
+			fmt.Fprint(r.Stdout, server, ": skipped\n")
+			continue
+		case outcome.Err != nil:
+			fmt.Fprint(r.Stdout, server, ": error\n")
+			continue
+		}
+
+		a := expected.Stdout()
+		b := outcome.Stdout()
+
+		if a == b {
+			fmt.Fprint(r.Stdout, server, ": identical\n")
+			continue
+		}
+
+		diff, err := UnifiedDiff(reference, server, a, b)
+		if err != nil {
+			fmt.Fprint(r.Stderr, server, ": error: ", err, "\n")
+			continue
+		}
+
+		// The output may only differ in the trailing newline
+		if diff == "" {
+			fmt.Fprint(r.Stdout, server, ": identical\n")
+			continue
+		}
+
+		fmt.Fprint(r.Stdout, server, ": differs\n", diff)
+	}
+}
+
+// UnifiedDiff returns the unified diff between the texts a and b, with the
+// given names in the header. Returns an empty string if the texts only
+// differ in their trailing newline.
+func UnifiedDiff(
+	nameA string,
+	nameB string,
+	a string,
+	b string,
+) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(a),
+		B:        splitLines(b),
+		FromFile: nameA,
+		ToFile:   nameB,
+		Context:  diffContext,
+	})
+}
+
+// firstComparable returns the first server that was neither skipped nor
+// failed to run, or the first server if there is no such server.
+func (r *Report) firstComparable() string {
+	for _, server := range r.order {
+		outcome := r.servers[server]
+		if !outcome.Skipped && outcome.Err == nil {
+			return server
+		}
+	}
+
+	return r.order[0]
+}
+
+// splitLines splits the text into lines, each ending with a newline.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return difflib.SplitLines(strings.TrimSuffix(text, "\n"))
+}
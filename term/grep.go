@@ -0,0 +1,109 @@
+package term
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxMatches limits the matching lines kept per server in the match mode.
+const maxMatches = 1000
+
+// shows returns true if the given line of output passes the grep filters.
+func (r *Report) shows(line string) bool {
+	line = strings.TrimSuffix(line, "\n")
+
+	if r.Grep != nil && !r.Grep.MatchString(line) {
+		return false
+	}
+
+	if r.GrepV != nil && r.GrepV.MatchString(line) {
+		return false
+	}
+
+	return true
+}
+
+// filter returns the lines of the given output that pass the grep filters.
+func (r *Report) filter(output string) string {
+	if r.Grep == nil && r.GrepV == nil {
+		return output
+	}
+
+	var filtered strings.Builder
+
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if line != "" && r.shows(line) {
+			filtered.WriteString(line)
+		}
+	}
+
+	return filtered.String()
+}
+
+// match counts the lines of the given output that pass the grep filters,
+// and keeps them to be shown once the server is done. Must be called with
+// the lock held.
+func (r *Report) match(outcome *Outcome, output Output) {
+	for _, line := range strings.SplitAfter(output.Text, "\n") {
+		if line == "" || !r.shows(line) {
+			continue
+		}
+
+		outcome.matched++
+		if len(outcome.matches) < maxMatches {
+			output.Text = line
+			outcome.matches = append(outcome.matches, output)
+		}
+	}
+}
+
+// allMatched returns true if all servers that were not skipped matched.
+// Must be called with the lock held.
+func (r *Report) allMatched() bool {
+	for _, outcome := range r.servers {
+		if outcome.Skipped {
+			continue
+		}
+
+		if outcome.matched == 0 || outcome.Err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// printMatches prints the server with a ✓ if any line matched, x otherwise,
+// followed by the matching lines, unless it was printed before. Must be
+// called with the lock held.
+func (r *Report) printMatches(outcome *Outcome) {
+	if outcome.printed {
+		return
+	}
+	outcome.printed = true
+
+	switch {
+	case outcome.Skipped:
+		fmt.Fprint(r.Stdout, outcome.Server, ": ",
+			r.paint(yellow, "skipped"), "\n")
+		return
+	case outcome.matched == 0:
+		fmt.Fprint(r.Stdout, outcome.Server, ": ", r.paint(red, "x"), "\n")
+		return
+	}
+
+	fmt.Fprint(r.Stdout, outcome.Server, ": ", r.paint(green, "✓"), "\n")
+
+	for _, match := range outcome.matches {
+		text := strings.TrimSuffix(match.Text, "\n")
+		if match.Stderr {
+			text = r.paint(red, text)
+		}
+
+		fmt.Fprint(r.Stdout, "  ", r.timestamp(match.Time), text, "\n")
+	}
+
+	if more := outcome.matched - len(outcome.matches); more > 0 {
+		fmt.Fprintf(r.Stdout, "  ... %d more matching lines\n", more)
+	}
+}
@@ -0,0 +1,63 @@
+package term
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrep(t *testing.T) {
+	rep, stdout, stderr := testReport(HostReport)
+	rep.Grep = regexp.MustCompile("o")
+	rep.GrepV = regexp.MustCompile("^b")
+
+	run(rep, "web1", "echo foo; echo bar; echo boo; echo no >&2")
+	run(rep, "web2", "echo baz")
+	rep.Finish()
+
+	assert.Equal(t, "web1: foo\n", stdout.String())
+	assert.Equal(t, "web1: no\n", stderr.String())
+	assert.True(t, rep.Success())
+}
+
+func TestGrepBuffered(t *testing.T) {
+	rep, stdout, _ := testReport(BufferedReport)
+	rep.Grep = regexp.MustCompile("a")
+
+	run(rep, "web1", "echo foo; echo bar; printf baz")
+	rep.Done("web1")
+
+	assert.Equal(t, "=== web1 (exit 0, 0s) ===\nbar\nbaz\n",
+		durations.ReplaceAllString(stdout.String(), ", 0s)"))
+}
+
+func TestMatchReport(t *testing.T) {
+	rep, stdout, _ := testReport(MatchReport)
+	rep.Grep = regexp.MustCompile("(?i)out of memory")
+
+	run(rep, "web1", "echo ok; echo 'Out of memory: Killed process 1'")
+	rep.Done("web1")
+	assert.Equal(t, "web1: ✓\n  Out of memory: Killed process 1\n",
+		stdout.String())
+	assert.True(t, rep.Success())
+
+	// Servers not marked as done are shown at the end, and the run is only
+	// a success if all servers matched
+	stdout.Reset()
+	run(rep, "web2", "echo ok")
+	rep.Finish()
+
+	assert.Equal(t, "web2: x\n", stdout.String())
+	assert.False(t, rep.Success())
+}
+
+func TestMatchReportLimit(t *testing.T) {
+	rep, stdout, _ := testReport(MatchReport)
+
+	run(rep, "web1", "seq 1002")
+	rep.Finish()
+
+	assert.Contains(t, stdout.String(), "  1000\n  ... 2 more matching lines\n")
+	assert.NotContains(t, stdout.String(), "  1001\n")
+}
@@ -0,0 +1,113 @@
+package term
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// junitSuites is the root element of a JUnit XML report.
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+// junitSuite is a JUnit test suite, a run of a command on all servers.
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+// junitCase is a JUnit test case, the commands run on a single server.
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+// junitMessage describes why a test case did not pass.
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes the outcomes as a JUnit XML report, with a test suite
+// of the given name, and a test case per server. Servers that exited with
+// an exit code that is not successful are failures, servers whose commands
+// could not be run, or did not finish are errors.
+func WriteJUnit(
+	w io.Writer,
+	name string,
+	start time.Time,
+	end time.Time,
+	outcomes []Outcome,
+) error {
+	suite := junitSuite{
+		Name:      name,
+		Tests:     len(outcomes),
+		Time:      junitTime(end.Sub(start)),
+		Timestamp: start.UTC().Format("2006-01-02T15:04:05"),
+		Cases:     make([]junitCase, 0, len(outcomes)),
+	}
+
+	for ix := range outcomes {
+		outcome := &outcomes[ix]
+
+		testcase := junitCase{
+			Name:      outcome.Server,
+			Classname: "ssh-each",
+			Time:      junitTime(outcome.Duration()),
+			SystemOut: outcome.Stdout(),
+			SystemErr: outcome.Stderr(),
+		}
+
+		switch {
+		case outcome.Skipped:
+			suite.Skipped++
+			testcase.Skipped = &junitMessage{"skipped by guard"}
+		case outcome.Err != nil:
+			suite.Errors++
+			testcase.Error = &junitMessage{outcome.Err.Error()}
+		case !outcome.Exited:
+			suite.Errors++
+			testcase.Error = &junitMessage{"did not finish"}
+		case outcome.Failed:
+			suite.Failures++
+			testcase.Failure = &junitMessage{
+				fmt.Sprintf("exit %d", outcome.ExitCode)}
+		}
+
+		suite.Cases = append(suite.Cases, testcase)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	err := encoder.Encode(junitSuites{Suites: []junitSuite{suite}})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// junitTime returns the given duration in seconds, as used by JUnit.
+func junitTime(duration time.Duration) string {
+	return fmt.Sprintf("%.3f", duration.Seconds())
+}
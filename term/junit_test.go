@@ -0,0 +1,62 @@
+package term
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var buf bytes.Buffer
+	err := WriteJUnit(&buf, "systemctl is-active nginx", start,
+		start.Add(2*time.Second), []Outcome{
+			{
+				Server:   "web1",
+				Start:    start,
+				End:      start.Add(1500 * time.Millisecond),
+				Exited:   true,
+				ExitCode: 0,
+				Output:   []Output{{Text: "active\n"}},
+			},
+			{
+				Server:   "web2",
+				Exited:   true,
+				ExitCode: 3,
+				Failed:   true,
+				Output: []Output{
+					{Text: "inactive\n"},
+					{Text: "<failed>\n", Stderr: true},
+				},
+			},
+			{Server: "web3", Err: errors.New("connection refused")},
+			{Server: "web4", Skipped: true},
+		})
+	assert.NoError(t, err)
+
+	assert.Equal(t, ``+
+		`<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="systemctl is-active nginx" tests="4" failures="1" errors="1" skipped="1" time="2.000" timestamp="2024-01-02T03:04:05">
+    <testcase name="web1" classname="ssh-each" time="1.500">
+      <system-out>active&#xA;</system-out>
+    </testcase>
+    <testcase name="web2" classname="ssh-each" time="0.000">
+      <failure message="exit 3"></failure>
+      <system-out>inactive&#xA;</system-out>
+      <system-err>&lt;failed&gt;&#xA;</system-err>
+    </testcase>
+    <testcase name="web3" classname="ssh-each" time="0.000">
+      <error message="connection refused"></error>
+    </testcase>
+    <testcase name="web4" classname="ssh-each" time="0.000">
+      <skipped message="skipped by guard"></skipped>
+    </testcase>
+  </testsuite>
+</testsuites>
+`, buf.String())
+}
@@ -0,0 +1,189 @@
+package term
+
+import (
+	"strings"
+	"time"
+)
+
+// maxCapture limits the output captured per server, to keep long running
+// commands (e.g. tailing logs) from exhausting the memory.
+const maxCapture = 1 << 20
+
+// Output is a piece of output written by a command.
+type Output struct {
+	// Step the output belongs to, if multiple commands are run per server.
+	Step string `json:"step,omitempty"`
+
+	// Stderr is true if the output was written to stderr.
+	Stderr bool `json:"stderr,omitempty"`
+
+	// Text that was written.
+	Text string `json:"text"`
+
+	// Time the output was received.
+	Time time.Time `json:"time"`
+}
+
+// Outcome is the outcome of all commands run on a single server.
+type Outcome struct {
+	// Server the commands were run on.
+	Server string
+
+	// Start is when the first command was started, End is when the last
+	// command ended.
+	Start time.Time
+	End   time.Time
+
+	// Exited is true if at least one command exited. ExitCode is the exit
+	// code of the last command that exited (guards are not considered).
+	Exited   bool
+	ExitCode int
+
+	// Failed is true if at least one command exited with an exit code that
+	// is not considered successful.
+	Failed bool
+
+	// Err is the last error that occurred trying to run a command.
+	Err error
+
+	// Skipped is true if the server was skipped by a guard.
+	Skipped bool
+
+	// Output written by the commands, if captured by the report.
+	Output []Output
+
+	// Truncated is true if not all output could be captured.
+	Truncated bool
+
+	// captured counts the bytes of output captured
+	captured int
+
+	// index is the position of the server, starting at 1
+	index int
+
+	// running counts the commands started, but not done yet
+	running int
+
+	// done is true once the server ran all its commands
+	done bool
+
+	// printed is true once the server was shown at the end (in the
+	// buffered and match modes)
+	printed bool
+
+	// matched counts the lines of output that matched in the match mode,
+	// matches holds the first of them
+	matched int
+	matches []Output
+}
+
+// State is the state of a server.
+type State uint8
+
+const (
+	// Queued servers are waiting for a worker.
+	Queued State = iota + 1
+
+	// Running servers are running a command.
+	Running
+
+	// Succeeded servers are done, and all their commands succeeded.
+	Succeeded
+
+	// Failed servers are done, and at least one command failed.
+	Failed
+
+	// Errored servers are done, and at least one command could not be run.
+	Errored
+
+	// Skipped servers were skipped by a guard.
+	Skipped
+)
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case Queued:
+		return "queued"
+	case Running:
+		return "running"
+	case Succeeded:
+		return "ok"
+	case Failed:
+		return "failed"
+	case Errored:
+		return "error"
+	case Skipped:
+		return "skipped"
+	default:
+		return "unknown"
+	}
+}
+
+// State returns the current state of the server.
+func (o *Outcome) State() State {
+	switch {
+	case o.Skipped:
+		return Skipped
+	case o.Start.IsZero():
+		return Queued
+	case o.running > 0:
+		return Running
+	case o.Err != nil:
+		return Errored
+	case !o.Success():
+		return Failed
+	default:
+		return Succeeded
+	}
+}
+
+// Duration returns the time it took to run all commands.
+func (o *Outcome) Duration() time.Duration {
+	if o.Start.IsZero() || o.End.IsZero() {
+		return 0
+	}
+
+	return o.End.Sub(o.Start)
+}
+
+// Success returns true if at least one command exited, and all commands
+// exited successfully.
+func (o *Outcome) Success() bool {
+	return o.Exited && !o.Failed && o.Err == nil
+}
+
+// Stdout returns the captured stdout output of all commands.
+func (o *Outcome) Stdout() string {
+	return JoinOutput(o.Output, false)
+}
+
+// Stderr returns the captured stderr output of all commands.
+func (o *Outcome) Stderr() string {
+	return JoinOutput(o.Output, true)
+}
+
+// JoinOutput returns the text of the given output written to stderr, or
+// to stdout.
+func JoinOutput(output []Output, stderr bool) string {
+	var text strings.Builder
+
+	for _, o := range output {
+		if o.Stderr == stderr {
+			text.WriteString(o.Text)
+		}
+	}
+
+	return text.String()
+}
+
+// capture adds the given output, unless the capture limit is reached.
+func (o *Outcome) capture(output Output) {
+	if o.captured+len(output.Text) > maxCapture {
+		o.Truncated = true
+		return
+	}
+
+	o.captured += len(output.Text)
+	o.Output = append(o.Output, output)
+}
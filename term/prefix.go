@@ -0,0 +1,70 @@
+package term
+
+import (
+	"io"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// PrefixData is passed to the template of the prefix shown before each line
+// of output in the host mode.
+type PrefixData struct {
+	// Server the output is from, including the step, if any.
+	Server string
+
+	// Stream is either "stdout" or "stderr".
+	Stream string
+
+	// Time the output was received.
+	Time time.Time
+
+	// Index of the server, starting at 1, in the order the servers were
+	// queued (see Report.Queue), which is the order they were read in.
+	Index int
+}
+
+// ParsePrefix parses the given prefix template, which may use the fields
+// of PrefixData (e.g. "{{.Index}} {{.Server}} {{.Stream}}: ").
+func ParsePrefix(text string) (*template.Template, error) {
+	tmpl, err := template.New("prefix").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	// Catch errors like unknown fields now, instead of for each line
+	if err := tmpl.Execute(io.Discard, PrefixData{}); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// prefix returns the prefix shown before each line of output in the host
+// mode. Must be called with the lock held.
+func (r *Report) prefix(data PrefixData) string {
+	var prefix string
+
+	if r.Prefix == nil {
+		prefix = data.Server + ": "
+	} else {
+		var text strings.Builder
+		if err := r.Prefix.Execute(&text, data); err != nil {
+			prefix = data.Server + ": "
+		} else {
+			prefix = text.String()
+		}
+	}
+
+	if !r.Align {
+		return prefix
+	}
+
+	width := utf8.RuneCountInString(prefix)
+	if width > r.prefixWidth {
+		r.prefixWidth = width
+	}
+
+	return prefix + strings.Repeat(" ", r.prefixWidth-width)
+}
@@ -0,0 +1,60 @@
+package term
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePrefix(t *testing.T) {
+	_, err := ParsePrefix("{{.Index}} {{.Server}} {{.Stream}} {{.Time}}")
+	assert.NoError(t, err)
+
+	_, err = ParsePrefix("{{.Server")
+	assert.Error(t, err)
+
+	_, err = ParsePrefix("{{.Host}}")
+	assert.Error(t, err)
+}
+
+func TestPrefixReport(t *testing.T) {
+	rep, stdout, stderr := testReport(HostReport)
+	rep.Prefix, _ = ParsePrefix("{{.Index}} {{.Server}} {{.Stream}}| ")
+
+	run(rep, "web1", "echo foo; echo bar >&2")
+
+	assert.Equal(t, "1 web1 stdout| foo\n", stdout.String())
+	assert.Equal(t, "1 web1 stderr| bar\n", stderr.String())
+}
+
+func TestAlignedReport(t *testing.T) {
+	rep, stdout, _ := testReport(HostReport)
+	rep.Align = true
+
+	run(rep, "web1", "echo foo")
+	run(rep, "web1.example.org", "echo bar")
+	run(rep, "web2", "echo baz")
+
+	// The prefix of web1 is padded once web1.example.org is associated
+	assert.Equal(t, ""+
+		"web1: foo\n"+
+		"web1.example.org: bar\n"+
+		"web2:             baz\n", stdout.String())
+}
+
+func TestAlignedReportQueued(t *testing.T) {
+	rep, stdout, _ := testReport(HostReport)
+	rep.Align = true
+	rep.Steps = []string{"check"}
+
+	rep.Queue("web1")
+	rep.Queue("web1.example.org")
+
+	// The width is known before the first line, including the steps
+	run(rep, "web1", "echo foo")
+	run(rep, "web1.example.org", "echo bar")
+
+	assert.Equal(t, ""+
+		"web1:                     foo\n"+
+		"web1.example.org:         bar\n", stdout.String())
+}
@@ -0,0 +1,164 @@
+package term
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// progressInterval is the time between updates of the progress line.
+const progressInterval = 200 * time.Millisecond
+
+// progress shows a live status line, while the commands run.
+type progress struct {
+	out   io.Writer
+	start time.Time
+
+	// shown is true while the line is shown
+	shown bool
+
+	// stop is closed to stop the updates, done is closed once they stopped
+	stop chan struct{}
+	done chan struct{}
+}
+
+// clearingWriter clears the progress line before writing, so that the line
+// is shown again below the output on the next update.
+type clearingWriter struct {
+	io.Writer
+	progress *progress
+}
+
+// Write implements io.Writer. Must be called with the report lock held.
+func (w *clearingWriter) Write(data []byte) (int, error) {
+	w.progress.clear()
+	return w.Writer.Write(data)
+}
+
+// ShowProgress shows a live status line on the given writer, usually a
+// terminal, with the number of servers in each state, the elapsed time and
+// the estimated time left. The line is updated until Finish is called.
+func (r *Report) ShowProgress(out io.Writer) {
+	p := &progress{
+		out:   out,
+		start: time.Now(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	r.progress = p
+	r.Stdout = &clearingWriter{Writer: r.Stdout, progress: p}
+	r.Stderr = &clearingWriter{Writer: r.Stderr, progress: p}
+	r.mu.Unlock()
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				r.mu.Lock()
+				p.draw(r.states())
+				r.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// stopProgress stops updating the progress line and clears it. Must be
+// called without holding the lock.
+func (r *Report) stopProgress() {
+	r.mu.Lock()
+	p := r.progress
+	r.progress = nil
+	r.mu.Unlock()
+
+	if p == nil {
+		return
+	}
+
+	close(p.stop)
+	<-p.done
+
+	r.mu.Lock()
+	p.clear()
+	r.mu.Unlock()
+}
+
+// states returns the number of servers in each state. Must be called with
+// the lock held.
+func (r *Report) states() map[State]int {
+	states := make(map[State]int)
+	for _, outcome := range r.servers {
+		states[outcome.State()]++
+	}
+	return states
+}
+
+// Summary returns the number of servers in each state, like "3 ok, 1
+// failed", omitting states without servers.
+func (r *Report) Summary() string {
+	r.mu.Lock()
+	states := r.states()
+	r.mu.Unlock()
+
+	parts := make([]string, 0, len(states))
+	for state := Queued; state <= Skipped; state++ {
+		if states[state] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", states[state], state))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// draw replaces the progress line with the given states.
+func (p *progress) draw(states map[State]int) {
+	parts := []string{
+		fmt.Sprintf("%d queued", states[Queued]),
+		fmt.Sprintf("%d running", states[Running]),
+		fmt.Sprintf("%d ok", states[Succeeded]),
+		fmt.Sprintf("%d failed", states[Failed]),
+	}
+
+	if states[Errored] > 0 {
+		parts = append(parts, fmt.Sprintf("%d errors", states[Errored]))
+	}
+	if states[Skipped] > 0 {
+		parts = append(parts, fmt.Sprintf("%d skipped", states[Skipped]))
+	}
+
+	elapsed := time.Since(p.start)
+	parts = append(parts,
+		fmt.Sprintf("%s elapsed", elapsed.Round(time.Second)))
+
+	// The time left is estimated from the average time per server so far
+	left := states[Queued] + states[Running]
+	done := states[Succeeded] + states[Failed] + states[Errored] +
+		states[Skipped]
+
+	if done > 0 && left > 0 {
+		eta := elapsed * time.Duration(left) / time.Duration(done)
+		parts = append(parts, fmt.Sprintf("ETA %s", eta.Round(time.Second)))
+	}
+
+	fmt.Fprint(p.out, "\r\x1b[K", strings.Join(parts, ", "))
+	p.shown = true
+}
+
+// clear removes the progress line, if it is shown.
+func (p *progress) clear() {
+	if !p.shown {
+		return
+	}
+
+	fmt.Fprint(p.out, "\r\x1b[K")
+	p.shown = false
+}
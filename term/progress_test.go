@@ -0,0 +1,65 @@
+package term
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/href/ssh-each/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressDraw(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := &progress{out: out, start: time.Now().Add(-10 * time.Second)}
+
+	p.draw(map[State]int{Queued: 3, Running: 1, Succeeded: 3, Failed: 1})
+	assert.Equal(t, "\r\x1b[K3 queued, 1 running, 3 ok, 1 failed, "+
+		"10s elapsed, ETA 10s", out.String())
+
+	out.Reset()
+	p.clear()
+	assert.Equal(t, "\r\x1b[K", out.String())
+
+	out.Reset()
+	p.clear()
+	assert.Equal(t, "", out.String())
+}
+
+func TestStates(t *testing.T) {
+	rep, _, _ := testReport(CheckReport)
+
+	rep.Queue("web1")
+	rep.Queue("web2")
+	rep.Queue("web3")
+	assert.Equal(t, map[State]int{Queued: 3}, rep.states())
+
+	cmd := exec.Command("true")
+	rep.Associate("web1", cmd)
+	rep.On(stream.CommandResult{Command: cmd, Result: stream.NewStartResult()})
+	assert.Equal(t, map[State]int{Queued: 2, Running: 1}, rep.states())
+
+	run(rep, "web2", "false")
+	rep.On(stream.CommandResult{Command: cmd, Result: stream.NewExitResult(0)})
+	assert.Equal(t, map[State]int{Queued: 1, Succeeded: 1, Failed: 1},
+		rep.states())
+}
+
+func TestShowProgress(t *testing.T) {
+	rep, stdout, _ := testReport(CheckReport)
+	out := &bytes.Buffer{}
+
+	rep.Queue("web1")
+	rep.ShowProgress(out)
+
+	time.Sleep(progressInterval * 3 / 2)
+	run(rep, "web1", "true")
+	rep.Finish()
+
+	// The line is cleared before the output, and at the end
+	assert.Equal(t, "web1: ✓\n", stdout.String())
+	assert.True(t, strings.HasPrefix(out.String(), "\r\x1b[K1 queued"))
+	assert.True(t, strings.HasSuffix(out.String(), "\r\x1b[K"))
+}
@@ -2,9 +2,15 @@ package term
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"text/template"
+	"time"
 
 	"github.com/href/ssh-each/stream"
 )
@@ -33,13 +39,62 @@ const (
 
 	// SilentReport suppresses all output
 	SilentReport
+
+	// DiffReport shows the difference of each server's output to the output
+	// of a reference server, once all commands are done.
+	DiffReport
+
+	// BufferedReport shows the output of each server as a single block,
+	// once the server is done.
+	BufferedReport
+
+	// MatchReport shows the hostname and a ✓ if any line of output matched
+	// the grep filters, x otherwise, followed by the matching lines, once
+	// the server is done.
+	MatchReport
+
+	// TableReport shows a table with a row per server, and a column per
+	// field of their output, parsed as JSON or key=value pairs, once all
+	// commands are done.
+	TableReport
+
+	// AggregateReport shows statistics of the number each server wrote to
+	// stdout (count, min, max, mean and percentiles), and the servers whose
+	// number is an outlier, once all commands are done.
+	AggregateReport
+
+	// TAPReport shows the result of each server as a test of the Test
+	// Anything Protocol, once all commands are done.
+	TAPReport
+
+	// MarkdownReport shows a Markdown table with the status, exit code,
+	// duration and first line of output of each server, once all commands
+	// are done.
+	MarkdownReport
 )
 
 const (
 	MinReport = HostReport
-	MaxReport = SilentReport
+	MaxReport = MarkdownReport
 )
 
+// Streams returns true if the mode shows the output of the commands while
+// they run.
+func (m ReportMode) Streams() bool {
+	return m == HostReport || m == PlainReport
+}
+
+// Final returns true if the mode only shows output once all servers are
+// done (e.g. the diff mode).
+func (m ReportMode) Final() bool {
+	switch m {
+	case DiffReport, TableReport, AggregateReport, TAPReport, MarkdownReport:
+		return true
+	default:
+		return false
+	}
+}
+
 // ReportModeFromString returns the ReportMode for the given string. Uses the
 // comma ok idiom to indicate if that worked.
 func ReportModeFromString(mode string) (ReportMode, bool) {
@@ -58,19 +113,178 @@ func ReportModeFromString(mode string) (ReportMode, bool) {
 		return ExitReport, true
 	case "silent":
 		return SilentReport, true
+	case "diff":
+		return DiffReport, true
+	case "buffered":
+		return BufferedReport, true
+	case "match":
+		return MatchReport, true
+	case "table":
+		return TableReport, true
+	case "aggregate":
+		return AggregateReport, true
+	case "tap":
+		return TAPReport, true
+	case "markdown":
+		return MarkdownReport, true
+	default:
+		return 0, false
+	}
+}
+
+// Timestamps defines if and how output lines are timestamped.
+type Timestamps uint8
+
+const (
+	// NoTimestamps shows no timestamps, default.
+	NoTimestamps Timestamps = iota
+
+	// AbsoluteTimestamps shows the date and time the output was received.
+	AbsoluteTimestamps
+
+	// RelativeTimestamps shows the time since the start of the run.
+	RelativeTimestamps
+)
+
+// TimestampsFromString returns the Timestamps for the given string. Uses the
+// comma ok idiom to indicate if that worked.
+func TimestampsFromString(timestamps string) (Timestamps, bool) {
+	switch timestamps {
+	case "", "none":
+		return NoTimestamps, true
+	case "absolute":
+		return AbsoluteTimestamps, true
+	case "relative":
+		return RelativeTimestamps, true
 	default:
 		return 0, false
 	}
 }
 
+// sshFailure is the exit code of ssh if it failed itself (e.g. because it
+// could not connect, or authenticate), instead of the remote command.
+const sshFailure = 255
+
+// Origin describes what a command is run for.
+type Origin struct {
+	// Server the command is run on.
+	Server string
+
+	// Step the command belongs to, if multiple commands are run per server.
+	Step string
+
+	// Success returns true if the given exit code is considered successful.
+	// If nil, the report's success codes are used.
+	Success func(exitCode int) bool
+
+	// Guard is true if the command decides whether the server is used. The
+	// output of guards is not shown and their exit codes do not count
+	// towards the success of the report. If a guard fails, the server is
+	// reported as skipped, unless the guard could not be run at all (see
+	// onGuard).
+	Guard bool
+}
+
+// Name returns the name shown with the output of the command.
+func (o Origin) Name() string {
+	if o.Step == "" {
+		return o.Server
+	}
+
+	return fmt.Sprintf("%s [%s]", o.Server, o.Step)
+}
+
 // Report accepts stream.CommandResult instances, keeps track of their status
 // and offers various output modes.
 type Report struct {
+	// Stdout receives the output of the report, defaults to os.Stdout.
+	Stdout io.Writer
+
+	// Stderr receives the errors of the report, and the stderr output of
+	// the commands, defaults to os.Stderr.
+	Stderr io.Writer
+
+	// Reference is the server that the output of the other servers is
+	// compared to in DiffReport mode. If empty, the first server is used.
+	Reference string
+
+	// Capture is true if the output of all servers should be captured (see
+	// Outcomes). Some modes capture output regardless.
+	Capture bool
+
+	// Timestamps defines if output lines are timestamped, in the modes that
+	// show output (host and plain).
+	Timestamps Timestamps
+
+	// Prefix is the template of the prefix shown before each line of output
+	// in the host mode (see ParsePrefix). If nil, "{{.Server}}: " is used.
+	Prefix *template.Template
+
+	// Align is true if the prefixes are padded to the longest prefix of the
+	// servers queued or associated so far, so that the output of the servers
+	// lines up. Queue all servers before running commands, for the width to
+	// be known before the first line is shown.
+	Align bool
+
+	// Steps are the names of the steps run on each server (see Origin), so
+	// that aligned prefixes account for them when servers are queued.
+	Steps []string
+
+	// Ordered is true if the blocks of the buffered mode are shown in the
+	// order the servers were queued, instead of the order they are done.
+	// Each block is shown as soon as all servers before it are done.
+	Ordered bool
+
+	// Grep only shows the lines of output matching the expression, GrepV
+	// only shows the lines not matching it. Used by the modes that show
+	// output, and to decide if a server matched in the match mode. Nil
+	// expressions are ignored.
+	Grep  *regexp.Regexp
+	GrepV *regexp.Regexp
+
+	// Fields are the columns shown in the table mode. If empty, all fields
+	// found in the output of the servers are shown.
+	Fields []string
+
+	// TableFormat defines how the table of the table mode is rendered.
+	TableFormat TableFormat
+
+	// Color is true if the output written to Stdout is colorized: The
+	// server names in the host mode, and the status of each server.
+	// ColorStderr is true if the output written to Stderr is colorized:
+	// The server names in the host mode, and stderr output (in red).
+	Color       bool
+	ColorStderr bool
+
+	// Epoch is the start of the run, which relative timestamps refer to. If
+	// zero, the time of the first command started is used.
+	Epoch time.Time
+
 	exitCodes    []int
+	failures     int
+	skipped      []string
 	successCodes map[int]bool
 	mode         ReportMode
 	mu           *sync.Mutex
-	registry     map[*exec.Cmd]string
+	registry     map[*exec.Cmd]Origin
+
+	// servers holds the outcome of each server, in the order they were
+	// first associated with a command
+	servers map[string]*Outcome
+	order   []string
+
+	// prefixWidth is the width of the longest prefix so far
+	prefixWidth int
+
+	// progress is the live status line, if shown
+	progress *progress
+
+	// next is the position of the next server shown in ordered mode
+	next int
+
+	// unfinished holds the name of the server whose output ends with an
+	// unfinished line, for each writer
+	unfinished map[io.Writer]string
 }
 
 // NewReport creates a new report.
@@ -80,64 +294,285 @@ func NewReport(mode ReportMode) Report {
 	}
 
 	return Report{
+		Stdout:       os.Stdout,
+		Stderr:       os.Stderr,
 		mode:         mode,
 		exitCodes:    make([]int, 0),
 		mu:           &sync.Mutex{},
-		registry:     make(map[*exec.Cmd]string),
+		registry:     make(map[*exec.Cmd]Origin),
 		successCodes: map[int]bool{0: true},
+		servers:      make(map[string]*Outcome),
+		unfinished:   make(map[io.Writer]string),
 	}
 }
 
 // Associate links a string to a exec.Cmd pointer. Usually the name will be
 // the name of the server the command is run on.
 func (r *Report) Associate(name string, cmd *exec.Cmd) {
+	r.AssociateOrigin(cmd, Origin{Server: name})
+}
+
+// Queue adds a server that is waiting to run commands. Servers are added
+// automatically when associated with a command, but queueing them as soon
+// as they are known keeps track of the servers still waiting.
+func (r *Report) Queue(server string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.outcome(server)
+
+	if r.Align {
+		r.alignTo(Origin{Server: server})
+		for _, step := range r.Steps {
+			r.alignTo(Origin{Server: server, Step: step})
+		}
+	}
+}
+
+// outcome returns the outcome of the given server, which is added if it
+// is not known yet. Must be called with the lock held.
+func (r *Report) outcome(server string) *Outcome {
+	if outcome, ok := r.servers[server]; ok {
+		return outcome
+	}
+
+	r.order = append(r.order, server)
+	r.servers[server] = &Outcome{Server: server, index: len(r.order)}
+
+	return r.servers[server]
+}
+
+// AssociateOrigin links an origin to a exec.Cmd pointer.
+func (r *Report) AssociateOrigin(cmd *exec.Cmd, origin Origin) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.registry[cmd] = origin
+	r.outcome(origin.Server)
+
+	// Servers that were not queued are associated before they show output,
+	// so aligned prefixes already account for them
+	if r.Align {
+		r.alignTo(origin)
+	}
+}
+
+// alignTo widens aligned prefixes to fit the prefix of the given origin.
+// Must be called with the lock held.
+func (r *Report) alignTo(origin Origin) {
+	r.prefix(PrefixData{
+		Server: origin.Name(),
+		Stream: "stdout",
+		Time:   time.Now(),
+		Index:  r.servers[origin.Server].index,
+	})
+}
+
+// Notice prints a message about the given command, unless all output is
+// suppressed.
+func (r *Report) Notice(cmd *exec.Cmd, message string) {
 	r.mu.Lock()
-	r.registry[cmd] = name
-	r.mu.Unlock()
+	defer r.mu.Unlock()
+
+	if r.mode == SilentReport {
+		return
+	}
+
+	fmt.Fprint(r.Stderr, r.registry[cmd].Name(), ": ", message, "\n")
+}
+
+// Done marks the given server as done, once it ran all its commands.
+func (r *Report) Done(server string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	outcome := r.outcome(server)
+	outcome.done = true
+
+	switch {
+	case r.mode == BufferedReport && r.Ordered:
+		r.printDone()
+	case r.mode == BufferedReport:
+		r.printBlock(outcome)
+	case r.mode == MatchReport:
+		r.printMatches(outcome)
+	}
+}
+
+// Skipped returns the servers skipped because their guard failed.
+func (r *Report) Skipped() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.skipped...)
+}
+
+// Outcomes returns the outcome of each server, in the order they were first
+// associated with a command.
+func (r *Report) Outcomes() []Outcome {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	outcomes := make([]Outcome, 0, len(r.order))
+	for _, server := range r.order {
+		outcomes = append(outcomes, *r.servers[server])
+	}
+
+	return outcomes
 }
 
-// Success indicates if the run set of commands were a success.
+// Success indicates if the run set of commands were a success. Guards and
+// the servers skipped by them are not considered. In the match mode, all
+// servers must have matched instead.
 func (r *Report) Success() bool {
 	// If no command ran, this is not a success
 	if len(r.exitCodes) == 0 {
 		return false
 	}
 
-	// Otherwise, we have success if all commands have a successful exit code
-	for i := 0; i < len(r.exitCodes); i++ {
-		if !r.successCodes[r.exitCodes[i]] {
-			return false
-		}
+	if r.mode == MatchReport {
+		return r.allMatched()
 	}
-	return true
+
+	// Otherwise, we have success if all commands have a successful exit code
+	return r.failures == 0
 }
 
 // On is given a command result, which it tracks and outputs according to the
 // report mode set.
 func (r *Report) On(cmdresult stream.CommandResult) {
 	r.mu.Lock()
-	server := r.registry[cmdresult.Command]
-	r.mu.Unlock()
+	defer r.mu.Unlock()
 
+	origin := r.registry[cmdresult.Command]
+	server := origin.Name()
 	result := cmdresult.Result
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	outcome := r.outcome(origin.Server)
+
+	switch result.Type() {
+	case stream.StartResult:
+		if outcome.Start.IsZero() {
+			outcome.Start = result.Time()
+		}
+		if r.Epoch.IsZero() {
+			r.Epoch = result.Time()
+		}
+		outcome.running++
+	case stream.ExitResult:
+		outcome.End = result.Time()
+		outcome.running--
+	case stream.ErrorResult:
+		outcome.End = result.Time()
+		outcome.Err = result.Err()
+		outcome.running--
+	}
+
+	if origin.Guard {
+		r.onGuard(origin, outcome, result)
+		return
+	}
+
 	switch result.Type() {
 	case stream.StdoutResult:
-		r.printOutput(os.Stdout, server, result.Stdout())
+		output := Output{
+			Step: origin.Step, Text: result.Stdout(), Time: result.Time()}
+		if r.captures() {
+			outcome.capture(output)
+		}
+		if r.mode == MatchReport {
+			r.match(outcome, output)
+		}
+		r.printOutput(origin, result)
 	case stream.StderrResult:
-		r.printOutput(os.Stderr, server, result.Stderr())
+		output := Output{
+			Step: origin.Step, Stderr: true, Text: result.Stderr(),
+			Time: result.Time()}
+		if r.captures() {
+			outcome.capture(output)
+		}
+		if r.mode == MatchReport {
+			r.match(outcome, output)
+		}
+		r.printOutput(origin, result)
 	case stream.ErrorResult:
-		fmt.Fprint(os.Stderr, server, "error:", result.Err())
+		r.finishLines(server)
+		fmt.Fprint(r.Stderr, server, ": error: ", result.Err(), "\n")
 	case stream.ExitResult:
+		r.finishLines(server)
+		success := r.succeeded(origin, result.ExitCode())
+		if !success {
+			r.failures++
+			outcome.Failed = true
+		}
+		outcome.Exited = true
+		outcome.ExitCode = result.ExitCode()
 		r.exitCodes = append(r.exitCodes, result.ExitCode())
-		r.printResult(server, result.ExitCode())
+		r.printResult(server, result.ExitCode(), success)
 	}
 }
 
+// captures returns true if the output of the servers is captured.
+func (r *Report) captures() bool {
+	return r.Capture || r.mode == DiffReport || r.mode == BufferedReport ||
+		r.mode == TableReport || r.mode == AggregateReport ||
+		r.mode == MarkdownReport
+}
+
+// onGuard handles the results of guards, which are not shown, unless the
+// guard could not be run, or skipped the server. Guards that could not be
+// run, or that ssh could not run on the server (e.g. because it could not
+// connect), count as failures instead of skipping the server.
+func (r *Report) onGuard(
+	origin Origin,
+	outcome *Outcome,
+	result stream.Result,
+) {
+	switch result.Type() {
+	case stream.ErrorResult:
+		r.failures++
+		fmt.Fprint(r.Stderr, origin.Name(), ": error: ", result.Err(), "\n")
+	case stream.ExitResult:
+		exitCode := result.ExitCode()
+		if r.succeeded(origin, exitCode) {
+			return
+		}
+
+		if exitCode == sshFailure {
+			r.failures++
+			outcome.Failed = true
+			outcome.Exited = true
+			outcome.ExitCode = exitCode
+			r.exitCodes = append(r.exitCodes, exitCode)
+			r.printResult(origin.Name(), exitCode, false)
+			return
+		}
+
+		outcome.Skipped = true
+		r.skipped = append(r.skipped, origin.Server)
+		r.printSkipped(origin.Name())
+	}
+}
+
+// succeeded returns true if the exit code is considered successful for
+// commands of the given origin.
+func (r *Report) succeeded(origin Origin, exitCode int) bool {
+	if origin.Success != nil {
+		return origin.Success(exitCode)
+	}
+
+	return r.successCodes[exitCode]
+}
+
 // printOutput prints the given result if it's a stdout/stderr output.
-func (r *Report) printOutput(file *os.File, server string, output string) {
+func (r *Report) printOutput(origin Origin, result stream.Result) {
+	file, output, name := r.Stdout, result.Stdout(), "stdout"
+	stderr := result.Type() == stream.StderrResult
+	if stderr {
+		file, output, name = r.Stderr, result.Stderr(), "stderr"
+	}
+
+	output = r.filter(output)
 	if output == "" {
 		return
 	}
@@ -145,6 +580,20 @@ func (r *Report) printOutput(file *os.File, server string, output string) {
 	switch r.mode {
 	case SilentReport:
 		return
+	case DiffReport:
+		return
+	case BufferedReport:
+		return
+	case MatchReport:
+		return
+	case TableReport:
+		return
+	case AggregateReport:
+		return
+	case TAPReport:
+		return
+	case MarkdownReport:
+		return
 	case CheckReport:
 		return
 	case CheckYesReport:
@@ -153,34 +602,127 @@ func (r *Report) printOutput(file *os.File, server string, output string) {
 		return
 	case ExitReport:
 		return
-	case PlainReport:
-		fmt.Fprint(file, output)
-	case HostReport:
-		prefix := func() {
-			fmt.Fprint(file, server, ": ")
-		}
-		printPrefix := true
-		for _, char := range output {
-			if r.mode == HostReport && printPrefix {
-				prefix()
-				printPrefix = false
-			}
+	case PlainReport, HostReport:
+		paint := r.paint
+		if stderr {
+			paint = r.paintStderr
+		}
+
+		prefix := r.timestamp(result.Time())
+		if r.mode == HostReport {
+			prefix += paint(serverColor(origin.Server), r.prefix(PrefixData{
+				Server: origin.Name(),
+				Stream: name,
+				Time:   result.Time(),
+				Index:  r.servers[origin.Server].index,
+			}))
+		}
+
+		// In the host mode, output does not continue the unfinished line
+		// of another server (e.g. with --raw), but starts a new line
+		owner, unfinished := r.unfinished[file]
+		if unfinished && owner != origin.Name() && r.mode == HostReport {
+			fmt.Fprint(file, "\n")
+			unfinished = false
+		}
+
+		lineStart := !unfinished
+
+		if prefix == "" && !(stderr && r.ColorStderr) {
+			fmt.Fprint(file, output)
+			lineStart = strings.HasSuffix(output, "\n")
+		} else {
+			for _, line := range strings.SplitAfter(output, "\n") {
+				if line == "" {
+					continue
+				}
+
+				text, newline := strings.CutSuffix(line, "\n")
+				if stderr {
+					text = r.paintStderr(red, text)
+				}
+
+				if lineStart {
+					fmt.Fprint(file, prefix)
+				}
+
+				fmt.Fprint(file, text)
+				if newline {
+					fmt.Fprint(file, "\n")
+				}
 
-			fmt.Fprint(file, string(char))
-			if char == '\n' {
-				printPrefix = true
+				lineStart = newline
 			}
 		}
+
+		if lineStart {
+			delete(r.unfinished, file)
+		} else {
+			r.unfinished[file] = origin.Name()
+		}
 	default:
 		panic(fmt.Sprintf("unsupported mode: %d", r.mode))
 	}
 }
 
+// finishLines ends the unfinished lines of the given server in the host
+// mode, once its command is done, so that each server's output ends with a
+// newline.
+func (r *Report) finishLines(server string) {
+	if r.mode != HostReport {
+		return
+	}
+
+	for file, owner := range r.unfinished {
+		if owner == server {
+			fmt.Fprint(file, "\n")
+			delete(r.unfinished, file)
+		}
+	}
+}
+
+// timestamp returns the timestamp shown before the output received at the
+// given time, followed by a space, or an empty string if timestamps are
+// not shown.
+func (r *Report) timestamp(at time.Time) string {
+	switch r.Timestamps {
+	case AbsoluteTimestamps:
+		return at.Local().Format("2006-01-02 15:04:05.000 ")
+	case RelativeTimestamps:
+		return fmt.Sprintf("+%.3fs ", at.Sub(r.Epoch).Seconds())
+	default:
+		return ""
+	}
+}
+
+// printSkipped prints the given server as skipped, in the modes that show
+// the state of each server.
+func (r *Report) printSkipped(server string) {
+	switch r.mode {
+	case CheckReport, ExitReport:
+		fmt.Fprint(r.Stdout, server, ": ", r.paint(yellow, "skipped"), "\n")
+	}
+}
+
 // printResult prints the given result if it is an ExitResult
-func (r *Report) printResult(server string, exitCode int) {
+func (r *Report) printResult(server string, exitCode int, success bool) {
 	switch r.mode {
 	case SilentReport:
 		return
+	case DiffReport:
+		return
+	case BufferedReport:
+		return
+	case MatchReport:
+		return
+	case TableReport:
+		return
+	case AggregateReport:
+		return
+	case TAPReport:
+		return
+	case MarkdownReport:
+		return
 	case PlainReport:
 		return
 	case HostReport:
@@ -188,24 +730,60 @@ func (r *Report) printResult(server string, exitCode int) {
 	case CheckReport:
 		var mark string
 
-		if r.successCodes[exitCode] {
-			mark = "✓"
+		if success {
+			mark = r.paint(green, "✓")
 		} else {
-			mark = "x"
+			mark = r.paint(red, "x")
 		}
 
-		fmt.Fprint(os.Stdout, server, ": ", mark, "\n")
+		fmt.Fprint(r.Stdout, server, ": ", mark, "\n")
 	case CheckYesReport:
-		if r.successCodes[exitCode] {
-			fmt.Fprint(os.Stdout, server, ": ✓\n")
+		if success {
+			fmt.Fprint(r.Stdout, server, ": ", r.paint(green, "✓"), "\n")
 		}
 	case CheckNoReport:
-		if !r.successCodes[exitCode] {
-			fmt.Fprint(os.Stdout, server, ": x\n")
+		if !success {
+			fmt.Fprint(r.Stdout, server, ": ", r.paint(red, "x"), "\n")
 		}
 	case ExitReport:
-		fmt.Fprint(os.Stdout, server, ": ", exitCode, "\n")
+		color := green
+		if !success {
+			color = red
+		}
+
+		fmt.Fprint(r.Stdout, server, ": ",
+			r.paint(color, strconv.Itoa(exitCode)), "\n")
 	default:
 		panic(fmt.Sprintf("unsupported mode: %d", r.mode))
 	}
 }
+
+// Finish prints the parts of the report that are only available once all
+// commands are done.
+func (r *Report) Finish() {
+	r.stopProgress()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch r.mode {
+	case DiffReport:
+		r.printDiff()
+	case TableReport:
+		r.printTable()
+	case AggregateReport:
+		r.printAggregate()
+	case TAPReport:
+		r.printTAP()
+	case MarkdownReport:
+		r.printMarkdown()
+	case BufferedReport:
+		for _, server := range r.order {
+			r.printBlock(r.servers[server])
+		}
+	case MatchReport:
+		for _, server := range r.order {
+			r.printMatches(r.servers[server])
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package term
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/href/ssh-each/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+// testReport returns a report for the given mode, writing to buffers.
+func testReport(mode ReportMode) (*Report, *bytes.Buffer, *bytes.Buffer) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+	rep := NewReport(mode)
+	rep.Stdout = stdout
+	rep.Stderr = stderr
+
+	return &rep, stdout, stderr
+}
+
+// run runs the shell script locally, as if it was run on the server, and
+// passes the results to the report.
+func run(rep *Report, server string, script string) {
+	cmd := exec.Command("sh", "-c", script)
+	rep.Associate(server, cmd)
+
+	for result := range stream.StreamCommand(context.Background(), cmd) {
+		rep.On(stream.CommandResult{Command: cmd, Result: result})
+	}
+}
+
+func TestHostReport(t *testing.T) {
+	rep, stdout, stderr := testReport(HostReport)
+
+	run(rep, "web1", "echo foo; echo bar")
+	run(rep, "web2", "echo error >&2; exit 1")
+	run(rep, "web3", "printf baz")
+	rep.Finish()
+
+	assert.Equal(t, "web1: foo\nweb1: bar\nweb3: baz\n", stdout.String())
+	assert.Equal(t, "web2: error\n", stderr.String())
+	assert.False(t, rep.Success())
+}
+
+func TestTimestamps(t *testing.T) {
+	epoch := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+
+	for _, test := range []struct {
+		mode       ReportMode
+		timestamps Timestamps
+		expected   string
+	}{
+		{HostReport, RelativeTimestamps,
+			"+1.500s web1: foo\n+1.500s web1: bar\n"},
+		{PlainReport, RelativeTimestamps, "+1.500s foo\n+1.500s bar\n"},
+		{HostReport, AbsoluteTimestamps,
+			"2024-01-02 03:04:06.500 web1: foo\n" +
+				"2024-01-02 03:04:06.500 web1: bar\n"},
+	} {
+		rep, stdout, _ := testReport(test.mode)
+		rep.Timestamps = test.timestamps
+		rep.Epoch = epoch
+
+		cmd := exec.Command("true")
+		rep.Associate("web1", cmd)
+
+		output := stream.NewOutputResult(stream.StdoutResult, "foo\nbar\n")
+		rep.On(stream.CommandResult{
+			Command: cmd,
+			Result:  output.WithTime(epoch.Add(1500 * time.Millisecond)),
+		})
+
+		assert.Equal(t, test.expected, stdout.String())
+	}
+}
+
+func TestHostReportRaw(t *testing.T) {
+	rep, stdout, _ := testReport(HostReport)
+
+	web1, web2 := exec.Command("true"), exec.Command("true")
+	rep.Associate("web1", web1)
+	rep.Associate("web2", web2)
+
+	send := func(cmd *exec.Cmd, result stream.Result) {
+		rep.On(stream.CommandResult{Command: cmd, Result: result})
+	}
+
+	// Partial writes continue the line of the server, unless another
+	// server writes in between
+	send(web1, stream.NewOutputResult(stream.StdoutResult, "##"))
+	send(web1, stream.NewOutputResult(stream.StdoutResult, "##\n#"))
+	send(web2, stream.NewOutputResult(stream.StdoutResult, "foo\nba"))
+	send(web2, stream.NewOutputResult(stream.StdoutResult, "r\n"))
+	send(web1, stream.NewOutputResult(stream.StdoutResult, "#"))
+
+	// Unfinished lines are ended once the command is done
+	send(web1, stream.NewExitResult(0))
+	send(web2, stream.NewExitResult(0))
+
+	assert.Equal(t, ""+
+		"web1: ####\n"+
+		"web1: #\n"+
+		"web2: foo\n"+
+		"web2: bar\n"+
+		"web1: #\n", stdout.String())
+}
+
+func TestCheckReport(t *testing.T) {
+	rep, stdout, _ := testReport(CheckReport)
+
+	run(rep, "web1", "echo foo")
+	run(rep, "web2", "exit 1")
+	rep.Finish()
+
+	assert.Equal(t, "web1: ✓\nweb2: x\n", stdout.String())
+}
+
+func TestDiffReport(t *testing.T) {
+	rep, stdout, _ := testReport(DiffReport)
+
+	run(rep, "web1", "printf 'a\\nb\\nc\\n'")
+	run(rep, "web2", "printf 'a\\nb\\nc\\n'")
+	run(rep, "web3", "printf 'a\\nB\\nc\\n'")
+	run(rep, "web4", "printf 'a\\nb\\nc'")
+	rep.Finish()
+
+	assert.Equal(t, `web1: reference
+web2: identical
+web3: differs
+--- web1
++++ web3
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+web4: identical
+`, stdout.String())
+}
+
+func TestDiffReportReference(t *testing.T) {
+	rep, stdout, stderr := testReport(DiffReport)
+	rep.Reference = "web2"
+
+	run(rep, "web1", "echo a")
+	run(rep, "web2", "echo b")
+	rep.Finish()
+
+	assert.Equal(t, `web2: reference
+web1: differs
+--- web2
++++ web1
+@@ -1 +1 @@
+-b
++a
+`, stdout.String())
+
+	rep, _, stderr = testReport(DiffReport)
+	rep.Reference = "web3"
+
+	run(rep, "web1", "echo a")
+	rep.Finish()
+
+	assert.Equal(t, "Reference server not found: web3\n", stderr.String())
+}
+
+func TestFinalModes(t *testing.T) {
+	final := []ReportMode{
+		DiffReport, TableReport, AggregateReport, TAPReport, MarkdownReport}
+
+	for mode := ReportMode(MinReport); mode <= MaxReport; mode++ {
+		assert.Equal(t, slices.Contains(final, mode), mode.Final(), mode)
+	}
+}
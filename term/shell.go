@@ -0,0 +1,244 @@
+package term
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/href/ssh-each/ssh"
+)
+
+// ShellRunner runs a command on the given targets and reports the results
+// using the given report mode. It returns once all commands are done, or
+// once the context is cancelled.
+type ShellRunner func(
+	ctx context.Context,
+	command string,
+	targets []ssh.Target,
+	mode ReportMode,
+)
+
+// Shell is an interactive prompt that runs each command entered on a set
+// of targets. Lines starting with ':' are built-in commands (see :help).
+type Shell struct {
+	// Targets are the targets commands are run on, unless excluded.
+	Targets []ssh.Target
+
+	// Mode is the report mode used for commands.
+	Mode ReportMode
+
+	// Run runs each command entered.
+	Run ShellRunner
+
+	// CheckMode returns an error if a mode selected through :mode cannot be
+	// used (e.g. because it requires an option that is not set). If nil, all
+	// modes are accepted.
+	CheckMode func(ReportMode) error
+
+	// In is where commands are read from.
+	In io.Reader
+
+	// Out is where the prompt and the output of built-ins is written to.
+	Out io.Writer
+
+	// exclude contains the exclusions added through :exclude
+	exclude *ssh.Exclusion
+
+	// history of the commands run
+	history []string
+}
+
+// shellHelp describes the built-in commands.
+const shellHelp = `Commands are run on all active servers. Built-in commands:
+  :hosts            list the active servers
+  :exclude PATTERN  exclude servers (hosts, globs, /regex/, @file)
+  :reset            use all servers again, undoing :exclude
+  :mode MODE        change the output mode (host, plain, check, ...)
+  :history          list the commands run so far
+  !N                run the N-th command of the history again
+  !!                run the last command again
+  :help             show this help
+  :quit             leave the shell (or Ctrl-D)
+`
+
+// TerminalInput returns the reader commands should be read from in an
+// interactive shell. If stdin is used to pass servers, the terminal is
+// opened directly.
+func TerminalInput() (io.Reader, error) {
+	if !HasStdin() {
+		return os.Stdin, nil
+	}
+
+	return os.Open("/dev/tty")
+}
+
+// Loop reads and runs commands until the input is exhausted or :quit is
+// entered. An interrupt cancels the command currently running, without
+// leaving the shell.
+func (s *Shell) Loop() error {
+	s.exclude = &ssh.Exclusion{}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	// The cancel function of the command currently running, if any
+	var mu sync.Mutex
+	var cancel context.CancelFunc
+
+	go func() {
+		for range interrupts {
+			mu.Lock()
+			if cancel != nil {
+				cancel()
+			} else {
+				fmt.Fprint(s.Out, "\n(use :quit or Ctrl-D to leave)\n")
+			}
+			mu.Unlock()
+		}
+	}()
+
+	fmt.Fprintf(s.Out, "%d servers loaded, enter :help for help\n",
+		len(s.Targets))
+
+	scanner := bufio.NewScanner(s.In)
+	for {
+		fmt.Fprint(s.Out, "ssh-each> ")
+
+		if !scanner.Scan() {
+			fmt.Fprintln(s.Out)
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			continue
+		case line == ":quit" || line == ":exit":
+			return nil
+		case strings.HasPrefix(line, ":"):
+			s.builtin(line)
+			continue
+		case strings.HasPrefix(line, "!"):
+			recalled, ok := s.recall(line)
+			if !ok {
+				fmt.Fprintf(s.Out, "No such command in history: %s\n", line)
+				continue
+			}
+			fmt.Fprintln(s.Out, recalled)
+			line = recalled
+		}
+
+		s.history = append(s.history, line)
+
+		targets := s.active()
+		if len(targets) == 0 {
+			fmt.Fprintln(s.Out, "No active servers")
+			continue
+		}
+
+		ctx, done := context.WithCancel(context.Background())
+		mu.Lock()
+		cancel = done
+		mu.Unlock()
+
+		s.Run(ctx, line, targets, s.Mode)
+
+		mu.Lock()
+		cancel = nil
+		mu.Unlock()
+		done()
+	}
+}
+
+// active returns the targets that are not excluded.
+func (s *Shell) active() []ssh.Target {
+	targets := make([]ssh.Target, 0, len(s.Targets))
+	for _, target := range s.Targets {
+		if !s.exclude.Match(target.Destination) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// recall returns the command referenced by !N or !! from the history.
+func (s *Shell) recall(line string) (string, bool) {
+	if line == "!!" {
+		if len(s.history) == 0 {
+			return "", false
+		}
+		return s.history[len(s.history)-1], true
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(s.history) {
+		return "", false
+	}
+
+	return s.history[n-1], true
+}
+
+// builtin runs the given built-in command.
+func (s *Shell) builtin(line string) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case ":help":
+		fmt.Fprint(s.Out, shellHelp)
+	case ":hosts":
+		targets := s.active()
+		for _, target := range targets {
+			fmt.Fprintln(s.Out, target.Server)
+		}
+		fmt.Fprintf(s.Out, "%d of %d servers active\n",
+			len(targets), len(s.Targets))
+	case ":exclude":
+		if arg == "" {
+			fmt.Fprintln(s.Out, "Usage: :exclude PATTERN")
+			return
+		}
+		for _, item := range strings.Fields(arg) {
+			if err := s.exclude.Add(item); err != nil {
+				fmt.Fprintln(s.Out, "Invalid exclude:", err)
+				return
+			}
+		}
+		fmt.Fprintf(s.Out, "%d of %d servers active\n",
+			len(s.active()), len(s.Targets))
+	case ":reset":
+		s.exclude = &ssh.Exclusion{}
+		fmt.Fprintf(s.Out, "%d servers active\n", len(s.Targets))
+	case ":mode":
+		if arg == "" {
+			fmt.Fprintln(s.Out, "Usage: :mode MODE")
+			return
+		}
+		mode, ok := ReportModeFromString(arg)
+		if !ok {
+			fmt.Fprintln(s.Out, "Unknown report mode:", arg)
+			return
+		}
+		if s.CheckMode != nil {
+			if err := s.CheckMode(mode); err != nil {
+				fmt.Fprintln(s.Out, err)
+				return
+			}
+		}
+		s.Mode = mode
+	case ":history":
+		for ix, command := range s.history {
+			fmt.Fprintf(s.Out, "%4d  %s\n", ix+1, command)
+		}
+	default:
+		fmt.Fprintf(s.Out, "Unknown command %s, enter :help for help\n", name)
+	}
+}
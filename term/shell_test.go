@@ -0,0 +1,82 @@
+package term
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/href/ssh-each/ssh"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShell(t *testing.T) {
+	type run struct {
+		command string
+		servers []string
+		mode    ReportMode
+	}
+	runs := []run{}
+
+	targets := []ssh.Target{}
+	for _, server := range []string{"web1", "web2", "db1"} {
+		targets = append(targets, ssh.Target{
+			Destination: *ssh.ParseDestination(server),
+			Server:      server,
+		})
+	}
+
+	out := &bytes.Buffer{}
+	shell := Shell{
+		Targets: targets,
+		Mode:    HostReport,
+		In: strings.NewReader(strings.Join([]string{
+			"uptime",
+			":exclude db*",
+			":mode match",
+			":mode check",
+			"whoami",
+			"!1",
+			":reset",
+			"!!",
+			":history",
+			":quit",
+			"not run",
+		}, "\n")),
+		Out: out,
+		CheckMode: func(mode ReportMode) error {
+			if mode == MatchReport {
+				return errors.New("--mode match requires --grep or --grep-v")
+			}
+			return nil
+		},
+		Run: func(
+			ctx context.Context,
+			command string,
+			targets []ssh.Target,
+			mode ReportMode,
+		) {
+			servers := []string{}
+			for _, target := range targets {
+				servers = append(servers, target.Server)
+			}
+			runs = append(runs, run{command, servers, mode})
+		},
+	}
+
+	assert.NoError(t, shell.Loop())
+
+	all := []string{"web1", "web2", "db1"}
+	web := []string{"web1", "web2"}
+
+	assert.Equal(t, []run{
+		{"uptime", all, HostReport},
+		{"whoami", web, CheckReport},
+		{"uptime", web, CheckReport},
+		{"uptime", all, CheckReport},
+	}, runs)
+
+	assert.Contains(t, out.String(), "   4  uptime\n")
+	assert.Contains(t, out.String(), "--mode match requires --grep")
+}
@@ -0,0 +1,96 @@
+package term
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// printTAP prints the result of each server as a TAP test, with the exit
+// code or error of failed servers as diagnostics. Must be called with the
+// lock held.
+func (r *Report) printTAP() {
+	fmt.Fprint(r.Stdout, "TAP version 13\n")
+	fmt.Fprintf(r.Stdout, "1..%d\n", len(r.order))
+
+	for ix, server := range r.order {
+		outcome := r.servers[server]
+
+		switch {
+		case outcome.Skipped:
+			fmt.Fprintf(r.Stdout, "ok %d - %s # SKIP guard failed\n",
+				ix+1, server)
+		case outcome.Success():
+			fmt.Fprintf(r.Stdout, "ok %d - %s\n", ix+1, server)
+		default:
+			fmt.Fprintf(r.Stdout, "not ok %d - %s\n", ix+1, server)
+			fmt.Fprint(r.Stdout, "# ", summaryResult(outcome), "\n")
+		}
+	}
+}
+
+// printMarkdown prints a Markdown table with the status, exit code,
+// duration and first line of output of each server. Must be called with
+// the lock held.
+func (r *Report) printMarkdown() {
+	printMarkdownRow(r.Stdout, []string{
+		"host", "status", "exit code", "duration", "output"})
+	printMarkdownRow(r.Stdout, []string{"---", "---", "---:", "---:", "---"})
+
+	for _, server := range r.order {
+		outcome := r.servers[server]
+
+		exitCode := ""
+		if outcome.Exited {
+			exitCode = strconv.Itoa(outcome.ExitCode)
+		}
+
+		duration := ""
+		if outcome.Duration() > 0 {
+			duration = outcome.Duration().Round(time.Millisecond).String()
+		}
+
+		output := firstLine(outcome)
+		if output != "" {
+			output = "`" + strings.ReplaceAll(output, "`", "'") + "`"
+		}
+
+		printMarkdownRow(r.Stdout, []string{
+			server, outcome.State().String(), exitCode, duration, output})
+	}
+}
+
+// leaveOut lists the server on stderr, with the reason it is left out of
+// the output of the report (e.g. the table).
+func (r *Report) leaveOut(outcome *Outcome, reason string) {
+	fmt.Fprintf(r.Stderr, "%s: left out (%s)\n", outcome.Server, reason)
+}
+
+// summaryResult returns why the server did not succeed, like "exit 3".
+func summaryResult(outcome *Outcome) string {
+	switch {
+	case outcome.Err != nil:
+		return fmt.Sprintf("error: %s", outcome.Err)
+	case outcome.Exited:
+		return fmt.Sprintf("exit %d", outcome.ExitCode)
+	case outcome.Start.IsZero():
+		return "did not run"
+	default:
+		return "did not finish"
+	}
+}
+
+// firstLine returns the first line of output of the server that is not
+// blank, without surrounding whitespace.
+func firstLine(outcome *Outcome) string {
+	for _, output := range outcome.Output {
+		for _, line := range strings.Split(output.Text, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				return line
+			}
+		}
+	}
+
+	return ""
+}
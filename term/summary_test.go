@@ -0,0 +1,57 @@
+package term
+
+import (
+	"os/exec"
+	"regexp"
+	"testing"
+
+	"github.com/href/ssh-each/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+// cells matches the duration cells of the Markdown summary
+var cells = regexp.MustCompile(`\| [0-9.]+m?s \|`)
+
+// skip runs a failing guard for the server, so that it is skipped.
+func skip(rep *Report, server string) {
+	cmd := exec.Command("false")
+	rep.AssociateOrigin(cmd, Origin{Server: server, Guard: true})
+	rep.On(stream.CommandResult{Command: cmd, Result: stream.NewExitResult(1)})
+}
+
+func TestTAPReport(t *testing.T) {
+	rep, stdout, _ := testReport(TAPReport)
+
+	run(rep, "web1", "echo foo")
+	run(rep, "web2", "echo bar; exit 3")
+	skip(rep, "web3")
+	rep.Queue("web4")
+	rep.Finish()
+
+	assert.Equal(t, ""+
+		"TAP version 13\n"+
+		"1..4\n"+
+		"ok 1 - web1\n"+
+		"not ok 2 - web2\n"+
+		"# exit 3\n"+
+		"ok 3 - web3 # SKIP guard failed\n"+
+		"not ok 4 - web4\n"+
+		"# did not run\n", stdout.String())
+}
+
+func TestMarkdownReport(t *testing.T) {
+	rep, stdout, _ := testReport(MarkdownReport)
+
+	run(rep, "web1", "echo; echo '  a | b  '; echo c")
+	run(rep, "web2", "echo error >&2; exit 3")
+	skip(rep, "web3")
+	rep.Finish()
+
+	assert.Equal(t, ""+
+		"| host | status | exit code | duration | output |\n"+
+		"| --- | --- | ---: | ---: | --- |\n"+
+		"| web1 | ok | 0 | 1ms | `a \\| b` |\n"+
+		"| web2 | failed | 3 | 1ms | `error` |\n"+
+		"| web3 | skipped |  |  |  |\n",
+		cells.ReplaceAllString(stdout.String(), "| 1ms |"))
+}
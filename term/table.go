@@ -0,0 +1,251 @@
+package term
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// TableFormat defines how the table of the table mode is rendered.
+type TableFormat uint8
+
+const (
+	// TextTable renders aligned columns, default.
+	TextTable TableFormat = iota
+
+	// CSVTable renders comma separated values.
+	CSVTable
+
+	// MarkdownTable renders a Markdown table.
+	MarkdownTable
+)
+
+// TableFormatFromString returns the TableFormat for the given string. Uses
+// the comma ok idiom to indicate if that worked.
+func TableFormatFromString(format string) (TableFormat, bool) {
+	switch format {
+	case "", "text":
+		return TextTable, true
+	case "csv":
+		return CSVTable, true
+	case "markdown":
+		return MarkdownTable, true
+	default:
+		return 0, false
+	}
+}
+
+// record holds the fields parsed from the output of a server, in the order
+// they were found.
+type record struct {
+	keys   []string
+	values map[string]string
+}
+
+// set adds the given field to the record.
+func (r *record) set(key string, value string) {
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+
+	r.values[key] = value
+}
+
+// parseRecord parses the given output as JSON, or as lines of key=value
+// pairs. Nested JSON fields are flattened, using their path separated by
+// dots as key (e.g. "version.major", or "0.ifname" for arrays).
+func parseRecord(output string) (*record, error) {
+	rec := &record{values: make(map[string]string)}
+
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return nil, errors.New("no output")
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(output))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err == nil && !decoder.More() {
+		flatten(rec, "", value)
+		return rec, nil
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, errors.New("output is not JSON or key=value")
+		}
+
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' &&
+			value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+
+		rec.set(strings.TrimSpace(key), value)
+	}
+
+	return rec, nil
+}
+
+// flatten adds the given JSON value to the record, using the given path
+// as key for scalar values. Object keys are added in sorted order.
+func flatten(rec *record, path string, value any) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			flatten(rec, join(key), value[key])
+		}
+	case []any:
+		for ix, item := range value {
+			flatten(rec, join(strconv.Itoa(ix)), item)
+		}
+	case string:
+		rec.set(pathOrValue(path), value)
+	case nil:
+		rec.set(pathOrValue(path), "")
+	default:
+		rec.set(pathOrValue(path), fmt.Sprint(value))
+	}
+}
+
+// pathOrValue returns the given path, or "value" for the top-level value.
+func pathOrValue(path string) string {
+	if path == "" {
+		return "value"
+	}
+
+	return path
+}
+
+// printTable prints a table with a row per server, and a column per field,
+// using the fields of the report, or all fields found. Servers that were
+// skipped are left out, as are servers that did not succeed, or whose
+// output could not be parsed, which are listed on stderr. Must be called
+// with the lock held.
+func (r *Report) printTable() {
+	fields := r.Fields
+	records := make(map[string]*record, len(r.order))
+	servers := make([]string, 0, len(r.order))
+
+	for _, server := range r.order {
+		outcome := r.servers[server]
+		if outcome.Skipped {
+			continue
+		}
+
+		if !outcome.Success() {
+			r.leaveOut(outcome, summaryResult(outcome))
+			continue
+		}
+
+		rec, err := parseRecord(outcome.Stdout())
+		if err != nil {
+			r.leaveOut(outcome, err.Error())
+			continue
+		}
+
+		records[server] = rec
+		servers = append(servers, server)
+
+		if len(r.Fields) == 0 {
+			for _, key := range rec.keys {
+				if !slices.Contains(fields, key) {
+					fields = append(fields, key)
+				}
+			}
+		}
+	}
+
+	if len(servers) == 0 {
+		return
+	}
+
+	rows := make([][]string, 0, len(servers))
+	for _, server := range servers {
+		row := []string{server}
+		for _, field := range fields {
+			row = append(row, records[server].values[field])
+		}
+		rows = append(rows, row)
+	}
+
+	header := append([]string{"server"}, fields...)
+
+	switch r.TableFormat {
+	case CSVTable:
+		w := csv.NewWriter(r.Stdout)
+		w.Write(header)
+		w.WriteAll(rows)
+	case MarkdownTable:
+		printMarkdownRow(r.Stdout, header)
+		separator := make([]string, len(header))
+		for ix := range separator {
+			separator[ix] = "---"
+		}
+		printMarkdownRow(r.Stdout, separator)
+		for _, row := range rows {
+			printMarkdownRow(r.Stdout, row)
+		}
+	default:
+		w := tabwriter.NewWriter(r.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.ToUpper(header[0])+"\t"+
+			strings.Join(header[1:], "\t"))
+		for _, row := range rows {
+			for ix := range row {
+				row[ix] = tableCell(row[ix])
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		w.Flush()
+	}
+}
+
+// tableCell returns the value shown in a cell of the text table, which
+// must fit on a single line, and is "-" if empty.
+func tableCell(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return strings.NewReplacer("\t", " ", "\n", " ").Replace(value)
+}
+
+// printMarkdownRow prints the given cells as a row of a Markdown table.
+func printMarkdownRow(w io.Writer, cells []string) {
+	escaped := make([]string, len(cells))
+	for ix, cell := range cells {
+		cell = strings.ReplaceAll(cell, "|", "\\|")
+		escaped[ix] = strings.ReplaceAll(cell, "\n", "<br>")
+	}
+
+	fmt.Fprint(w, "| ", strings.Join(escaped, " | "), " |\n")
+}
@@ -0,0 +1,78 @@
+package term
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRecord(t *testing.T) {
+	rec, err := parseRecord(`{"version": {"major": 2, "minor": 1}, "name": "app"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"name", "version.major", "version.minor"}, rec.keys)
+	assert.Equal(t, "2", rec.values["version.major"])
+
+	rec, err = parseRecord(`[{"ifname": "lo"}, {"ifname": "eth0", "up": true}]`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0.ifname", "1.ifname", "1.up"}, rec.keys)
+	assert.Equal(t, "true", rec.values["1.up"])
+
+	rec, err = parseRecord("# os-release\nNAME=\"Debian GNU/Linux\"\nID=debian\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"NAME", "ID"}, rec.keys)
+	assert.Equal(t, "Debian GNU/Linux", rec.values["NAME"])
+
+	rec, err = parseRecord("42\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "42", rec.values["value"])
+
+	_, err = parseRecord("foo\nbar\n")
+	assert.Error(t, err)
+
+	_, err = parseRecord("")
+	assert.Error(t, err)
+}
+
+func TestTableReport(t *testing.T) {
+	rep, stdout, stderr := testReport(TableReport)
+
+	run(rep, "web1", `echo '{"version": "1.2", "build": 7}'`)
+	run(rep, "web2", `echo 'version=1.3'`)
+	run(rep, "web3", `echo 'not a record'`)
+	run(rep, "web4", `echo 'version=1.4'; exit 2`)
+	rep.Finish()
+
+	assert.Equal(t, ""+
+		"SERVER  build  version\n"+
+		"web1    7      1.2\n"+
+		"web2    -      1.3\n", stdout.String())
+	assert.Equal(t, ""+
+		"web3: left out (output is not JSON or key=value)\n"+
+		"web4: left out (exit 2)\n", stderr.String())
+}
+
+func TestTableReportFormats(t *testing.T) {
+	rep, stdout, _ := testReport(TableReport)
+	rep.Fields = []string{"version", "name"}
+	rep.TableFormat = CSVTable
+
+	run(rep, "web1", `echo '{"version": "1.2", "name": "a, b"}'`)
+	run(rep, "web2", `echo '{"version": "1.3"}'`)
+	rep.Finish()
+
+	assert.Equal(t, ""+
+		"server,version,name\n"+
+		"web1,1.2,\"a, b\"\n"+
+		"web2,1.3,\n", stdout.String())
+
+	rep, stdout, _ = testReport(TableReport)
+	rep.TableFormat = MarkdownTable
+
+	run(rep, "web1", `echo 'cmd=a|b'`)
+	rep.Finish()
+
+	assert.Equal(t, ""+
+		"| server | cmd |\n"+
+		"| --- | --- |\n"+
+		"| web1 | a\\|b |\n", stdout.String())
+}
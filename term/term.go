@@ -5,6 +5,8 @@ import (
 	"io"
 	"os"
 	"strings"
+
+	xterm "golang.org/x/term"
 )
 
 // HasStdin returns true if an stdin file is attached
@@ -13,6 +15,16 @@ func HasStdin() bool {
 	return (stat.Mode() & os.ModeCharDevice) == 0
 }
 
+// IsTerminal returns true if the given writer is a terminal.
+func IsTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	return xterm.IsTerminal(int(file.Fd()))
+}
+
 // StdinReader returns a reader that reads from stdin
 func StdinReader() io.Reader {
 	return bufio.NewReader(os.Stdin)
@@ -20,37 +32,74 @@ func StdinReader() io.Reader {
 
 // CommaSeparatedReader returns a reader where every item found in a
 // comma separated string is returned as a new line.
+//
+// Since labels are comma separated as well, an item containing a '=' is
+// considered to be another label of the previous item, if that item has
+// labels (e.g. "web1#role=web,dc=ams,web2" results in two lines).
 func CommaSeparatedReader(items string) io.Reader {
-	return strings.NewReader(strings.ReplaceAll(items, ",", "\n"))
+	lines := make([]string, 0)
+
+	for _, item := range strings.Split(items, ",") {
+		last := len(lines) - 1
+		if last >= 0 && strings.Contains(lines[last], "#") &&
+			strings.Contains(item, "=") && !strings.Contains(item, "#") {
+			lines[last] += "," + item
+			continue
+		}
+
+		lines = append(lines, item)
+	}
+
+	return strings.NewReader(strings.Join(lines, "\n"))
 }
 
-// CombinedReader returns a reader that will first return one line for each
-// item in the given comma-separated string, followed by the lines read from
-// STDIN.
+// CombinedReader returns a reader that will first return the lines read from
+// STDIN, followed by one line for each item in the given comma-separated
+// string, followed by the lines of the given extra readers.
 //
-// If no items are given and no stdin is attached, nil is returned.
-func CombinedReader(items string) io.Reader {
-	var readers [2]io.Reader
+// If no items, no extra readers and no stdin are given, nil is returned.
+func CombinedReader(items string, extra ...io.Reader) io.Reader {
+	readers := make([]io.Reader, 0, 2+len(extra))
 
 	if HasStdin() {
-		readers[0] = StdinReader()
+		readers = append(readers, StdinReader())
 	}
 
 	if items != "" {
-		readers[1] = CommaSeparatedReader(items)
+		readers = append(readers, CommaSeparatedReader(items))
 	}
 
-	if readers[0] != nil && readers[1] != nil {
-		return io.MultiReader(readers[0], readers[1])
+	for _, r := range extra {
+		if r != nil {
+			readers = append(readers, r)
+		}
 	}
 
-	if readers[0] != nil {
+	switch len(readers) {
+	case 0:
+		return nil
+	case 1:
 		return readers[0]
 	}
 
-	if readers[1] != nil {
-		return readers[1]
+	// Separate the readers by newlines, in case one of them does not end
+	// with one (the resulting empty lines are ignored by consumers).
+	separated := make([]io.Reader, 0, len(readers)*2-1)
+	for i, r := range readers {
+		if i > 0 {
+			separated = append(separated, strings.NewReader("\n"))
+		}
+		separated = append(separated, r)
+	}
+
+	return io.MultiReader(separated...)
+}
+
+// LinesReader returns a reader that returns each of the given lines.
+func LinesReader(lines []string) io.Reader {
+	if len(lines) == 0 {
+		return nil
 	}
 
-	return nil
+	return strings.NewReader(strings.Join(lines, "\n") + "\n")
 }
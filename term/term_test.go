@@ -0,0 +1,21 @@
+package term
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommaSeparatedReader(t *testing.T) {
+	read := func(items string) string {
+		text, err := io.ReadAll(CommaSeparatedReader(items))
+		assert.NoError(t, err)
+		return string(text)
+	}
+
+	assert.Equal(t, "foo\nbar", read("foo,bar"))
+	assert.Equal(t, "foo#a=1,b=2\nbar", read("foo#a=1,b=2,bar"))
+	assert.Equal(t, "foo#canary\nbar", read("foo#canary,bar"))
+	assert.Equal(t, "foo\nbar#a=1\nbaz#b=2", read("foo,bar#a=1,baz#b=2"))
+}